@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/terorie/od-database-crawler/crawler"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Checkpoint is the saved state of an unfinished task.
+// Jobs that overflowed to disk are kept by goque in queue/<id>.
+type Checkpoint struct {
+	Task       crawler.Task
+	BaseUri    string
+	// Length of crawled/<id>.json at the checkpoint
+	ResultSize int64
+	State      crawler.State
+}
+
+func checkpointPath(websiteId uint64) string {
+	return path.Join("checkpoint", fmt.Sprintf("%d.gob", websiteId))
+}
+
+func LoadCheckpoint(websiteId uint64) (cp *Checkpoint, err error) {
+	f, err := os.Open(checkpointPath(websiteId))
+	if err != nil { return nil, err }
+	defer f.Close()
+
+	cp = new(Checkpoint)
+	err = gob.NewDecoder(f).Decode(cp)
+	if err != nil { return nil, err }
+	return cp, nil
+}
+
+// ListCheckpoints returns the saved state of all unfinished tasks.
+func ListCheckpoints() (cps []*Checkpoint) {
+	matches, _ := filepath.Glob(path.Join("checkpoint", "*.gob"))
+	for _, match := range matches {
+		var websiteId uint64
+		name := strings.TrimSuffix(path.Base(match), ".gob")
+		if _, err := fmt.Sscan(name, &websiteId); err != nil {
+			continue
+		}
+		cp, err := LoadCheckpoint(websiteId)
+		if err != nil {
+			logrus.WithError(err).
+				WithField("file", match).
+				Error("Failed to load checkpoint")
+			continue
+		}
+		cps = append(cps, cp)
+	}
+	return
+}
+
+func RemoveCheckpoint(websiteId uint64) {
+	err := os.Remove(checkpointPath(websiteId))
+	if err != nil && !os.IsNotExist(err) {
+		logrus.WithError(err).
+			Error("Failed to remove checkpoint")
+	}
+}
+
+func (cp *Checkpoint) save() error {
+	filePath := checkpointPath(cp.Task.WebsiteId)
+
+	// Write to temp file first, checkpoint is replaced atomically
+	f, err := ioutil.TempFile("checkpoint", "tmp")
+	if err != nil { return err }
+	err = gob.NewEncoder(f).Encode(cp)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filePath)
+}
+
+// Checkpoint stops the workers of the task,
+// saves its state and lets the workers continue.
+func (o *Remote) Checkpoint() error {
+	return o.Snapshot(o.saveCheckpoint)
+}
+
+// Suspend stops the workers of the task for good
+// and saves its state to be resumed on next start.
+// Returns false if the task has already finished crawling.
+// Watch returns afterwards and keeps the results file.
+func (o *Remote) Suspend() (bool, error) {
+	return o.OD.Suspend(o.saveCheckpoint)
+}
+
+// Called with all workers stopped
+func (o *Remote) saveCheckpoint(s *crawler.State) error {
+	cp := Checkpoint{
+		Task:    o.Task,
+		BaseUri: o.BaseUri.String(),
+		State:   *s,
+	}
+
+	if s.Started {
+		var err error
+		cp.ResultSize, err = o.syncResults()
+		if err != nil { return err }
+	}
+
+	return cp.save()
+}
+
+// syncResults waits until all collected results are
+// written and returns the length of the results file.
+func (o *Remote) syncResults() (int64, error) {
+	reply := make(chan int64, 1)
+	select {
+	case o.syncC <- reply:
+	case <-time.After(10 * time.Second):
+		return 0, fmt.Errorf("result collector not responding")
+	}
+	size := <-reply
+	if size < 0 {
+		return 0, fmt.Errorf("failed to sync results file")
+	}
+	return size, nil
+}
+
+// checkpointLoop saves the task state periodically
+// until stopCheckpoints is called.
+func (o *Remote) checkpointLoop() {
+	defer close(o.checkpointDone)
+	if config.Checkpoint <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(config.Checkpoint)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := o.Checkpoint(); err != nil {
+				logrus.WithError(err).
+					WithField("id", o.Task.WebsiteId).
+					Error("Failed to save checkpoint")
+			}
+		case <-o.checkpointStop:
+			return
+		}
+	}
+}
+
+func (o *Remote) stopCheckpoints() {
+	close(o.checkpointStop)
+	<-o.checkpointDone
+}
+
+// ScheduleCheckpoint resumes a task from its saved state.
+func ScheduleCheckpoint(remotes chan<- *Remote, cp *Checkpoint) {
+	var u fasturl.URL
+	if err := u.Parse(cp.BaseUri); err != nil {
+		logrus.WithError(err).
+			WithField("id", cp.Task.WebsiteId).
+			Error("Failed to resume task")
+		RemoveCheckpoint(cp.Task.WebsiteId)
+		return
+	}
+
+	remote := newRemote(&cp.Task, &u)
+	remote.resume = cp
+	remote.Restore(&cp.State)
+	if !remote.register() {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id":    cp.Task.WebsiteId,
+		"url":   cp.Task.Url,
+		"files": cp.State.FileCount,
+	}).Info("Resuming crawl")
+
+	globalWait.Add(1)
+	remotes <- remote
+}
+
+// SuspendTasks saves the state of all running tasks.
+func SuspendTasks() {
+	activeTasksLock.Lock()
+	defer activeTasksLock.Unlock()
+
+	for _, od := range activeTasks {
+		suspended, err := od.Suspend()
+		if err != nil {
+			logrus.WithError(err).
+				WithField("id", od.Task.WebsiteId).
+				Error("Failed to suspend task")
+		} else if suspended {
+			logrus.WithField("id", od.Task.WebsiteId).
+				Info("Task suspended")
+		}
+	}
+}
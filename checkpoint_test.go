@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"github.com/terorie/od-database-crawler/crawler"
+	"github.com/terorie/od-database-crawler/ds/redblackhash"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "od-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir("checkpoint", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	engine = crawler.New(crawler.Options{JobBufferSize: -1})
+
+	var u fasturl.URL
+	if err := u.Parse("http://example.org/pub/"); err != nil {
+		t.Fatal(err)
+	}
+	od := newRemote(&crawler.Task{WebsiteId: 42, Url: "http://example.org/pub"}, &u)
+
+	// Queue some jobs without crawling them
+	var jobs []crawler.JobGob
+	for _, path := range []string{"/pub/a/", "/pub/b/"} {
+		var gob crawler.JobGob
+		gob.ToGob(&crawler.Job{UriStr: "http://example.org" + path})
+		jobs = append(jobs, gob)
+	}
+	od.Restore(&crawler.State{
+		Started:   true,
+		FileCount: 3,
+		Jobs:      jobs,
+		Scanned:   []redblackhash.Key{{1}, {2}},
+	})
+	od.Pause()
+	results := make(chan crawler.File)
+	if err := od.Start(context.Background(), results); err != nil {
+		t.Fatal(err)
+	}
+
+	// Collect some results
+	f, err := os.Create("results.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	errC := make(chan error, 1)
+	go od.Collect(results, f, errC)
+	results <- crawler.File{Name: "a.txt", Path: "pub"}
+	results <- crawler.File{Name: "b.txt", Path: "pub"}
+
+	if err := od.Checkpoint(); err != nil {
+		t.Fatal("Failed to save checkpoint", err)
+	}
+	size, _ := f.Seek(0, io.SeekCurrent)
+
+	// Drop the jobs
+	od.Stop(crawler.StopCancel)
+	od.Wait.Wait()
+	od.Close()
+	close(results)
+	<-errC
+
+	cps := ListCheckpoints()
+	if len(cps) != 1 {
+		t.Fatalf("Expected 1 checkpoint, got %d", len(cps))
+	}
+	cp := cps[0]
+	if cp.Task.WebsiteId != 42 || cp.BaseUri != "http://example.org/pub/" {
+		t.Errorf("Unexpected task %+v (%s)", cp.Task, cp.BaseUri)
+	}
+	if cp.ResultSize != size || cp.State.FileCount != 3 {
+		t.Errorf("Expected %d bytes and 3 files, got %d and %d",
+			size, cp.ResultSize, cp.State.FileCount)
+	}
+	if len(cp.State.Jobs) != 2 || cp.State.Jobs[1].Uri != "http://example.org/pub/b/" {
+		t.Errorf("Unexpected jobs %+v", cp.State.Jobs)
+	}
+	if len(cp.State.Scanned) != 2 || cp.State.Scanned[0][0] != 1 || cp.State.Scanned[1][0] != 2 {
+		t.Errorf("Unexpected scanned keys %v", cp.State.Scanned)
+	}
+
+	// Resume into a new task
+	od2 := newRemote(&cp.Task, &u)
+	od2.Restore(&cp.State)
+	if !od2.LoadOrStoreKey(&redblackhash.Key{2}) {
+		t.Error("Restored task forgot scanned directory")
+	}
+
+	RemoveCheckpoint(42)
+	if len(ListCheckpoints()) != 0 {
+		t.Error("Checkpoint not removed")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"github.com/terorie/od-database-crawler/crawler"
 	"io"
 	"os"
 	"strings"
@@ -18,13 +19,13 @@ var config struct {
 	ServerTimeout time.Duration
 	Recheck    time.Duration
 	ChunkSize  int64
-	Retries    int
-	Workers    int
-	UserAgent  string
 	Tasks      int32
-	Verbose    bool
-	PrintHTTP  bool
-	JobBufferSize int
+	Checkpoint time.Duration
+	// Settings of the crawler
+	Crawl      crawler.Options
+	MetricsListen string
+	ControlListen string
+	UploadCompression string
 }
 
 var onlineMode bool
@@ -38,20 +39,42 @@ const (
 	ConfChunkSize  = "server.upload_chunk"
 	ConfUploadRetries = "server.upload_retries"
 	ConfUploadRetryInterval = "server.upload_retry_interval"
+	ConfUploadCompression = "server.upload_compression"
 
 	ConfTasks      = "crawl.tasks"
 	ConfRetries    = "crawl.retries"
 	ConfWorkers    = "crawl.connections"
+	ConfMaxWorkers = "crawl.max_connections"
+	ConfHostConnections = "crawl.host_connections"
+	ConfHostRate   = "crawl.host_rate"
 	ConfUserAgent  = "crawl.user-agent"
 	ConfDialTimeout = "crawl.dial_timeout"
 	ConfTimeout    = "crawl.timeout"
+	ConfProxy      = "crawl.proxy"
+	ConfTorProxy   = "crawl.tor_proxy"
 	ConfJobBufferSize = "crawl.job_buffer"
+	ConfQueueBackend = "crawl.queue_backend"
+	ConfTrustListing = "crawl.trust_listing"
+	ConfCheckpoint = "crawl.checkpoint"
+	ConfMaxRedirects = "crawl.max_redirects"
+	ConfRedirectPolicy = "crawl.redirect_policy"
+	ConfMaxDepth   = "crawl.max_depth"
+	ConfMaxPathLength = "crawl.max_path_length"
+	ConfMaxRepeats = "crawl.max_repeats"
+	ConfMaxFiles   = "crawl.max_files"
+	ConfMaxDirs    = "crawl.max_dirs"
+	ConfMaxDuration = "crawl.max_duration"
+	ConfMinListingScore = "crawl.min_listing_score"
+	ConfRobots     = "crawl.robots"
 
 	ConfCrawlStats = "output.crawl_stats"
 	ConfAllocStats = "output.resource_stats"
 	ConfVerbose    = "output.verbose"
 	ConfPrintHTTP  = "output.http"
 	ConfLogFile    = "output.log"
+	ConfMetrics    = "output.metrics"
+	ConfControl    = "output.control"
+	ConfFileMetadata = "output.file_metadata"
 )
 
 func prepareConfig() {
@@ -77,20 +100,58 @@ func prepareConfig() {
 
 	pf.Duration(ConfUploadRetryInterval, 30 * time.Second, "OD-DB: Time to wait between upload retries")
 
+	pf.String(ConfUploadCompression, "auto", "OD-DB: Result upload compression (auto, gzip, zstd, none)")
+
 	pf.Uint(ConfTasks, 100, "Crawler: Max concurrent tasks")
 
 	pf.Uint(ConfWorkers, 4, "Crawler: Connections per server")
 
+	pf.Uint(ConfMaxWorkers, 0, "Crawler: Adapt connections per server up to this many (0 = fixed)")
+
+	pf.Uint(ConfHostConnections, 0, "Crawler: Max connections per server across tasks (0 = no limit)")
+
+	pf.Float64(ConfHostRate, 0, "Crawler: Max requests per second per server across tasks (0 = no limit)")
+
 	pf.Uint(ConfRetries, 5, "Crawler: Request retries")
 
 	pf.Duration(ConfDialTimeout, 10 * time.Second, "Crawler: Handshake timeout")
 
 	pf.Duration(ConfTimeout, 30 * time.Second, "Crawler: Request timeout")
 
+	pf.String(ConfProxy, "", "Crawler: Proxy URL (http://, socks5:// or socks5h://)")
+
+	pf.String(ConfTorProxy, "", "Crawler: Proxy URL for .onion sites (e.g. socks5h://127.0.0.1:9050)")
+
 	pf.String(ConfUserAgent, "Mozilla/5.0 (X11; od-database-crawler) Gecko/20100101 Firefox/52.0", "Crawler: User-Agent")
 
 	pf.Uint(ConfJobBufferSize, 5000, "Crawler: Task queue cache size")
 
+	pf.String(ConfQueueBackend, "goque", "Crawler: Task queue store on disk (goque, bolt, memory)")
+
+	pf.String(ConfTrustListing, "exact", "Crawler: Use file info from listings (exact, approx, off)")
+
+	pf.Duration(ConfCheckpoint, time.Minute, "Crawler: Save progress interval (0 = disabled)")
+
+	pf.Uint(ConfMaxRedirects, 5, "Crawler: Max redirects per request (0 = don't follow)")
+
+	pf.String(ConfRedirectPolicy, "any", "Crawler: Redirects to follow (same-host, same-prefix, any)")
+
+	pf.Uint(ConfMaxDepth, 0, "Crawler: Max directory depth below the site URL (0 = no limit)")
+
+	pf.Uint(ConfMaxPathLength, 0, "Crawler: Max URL path length (0 = no limit)")
+
+	pf.Uint(ConfMaxRepeats, 0, "Crawler: Max occurrences of a path segment (0 = no limit)")
+
+	pf.Uint64(ConfMaxFiles, 0, "Crawler: Stop a task after this many files (0 = no limit)")
+
+	pf.Uint64(ConfMaxDirs, 0, "Crawler: Stop a task after this many directories (0 = no limit)")
+
+	pf.Duration(ConfMaxDuration, 0, "Crawler: Stop a task after this time (0 = no limit)")
+
+	pf.Float64(ConfMinListingScore, 0, "Crawler: Skip pages that look less like a listing (0 to 1, 0 = off)")
+
+	pf.Bool(ConfRobots, false, "Crawler: Honor robots.txt and Crawl-delay")
+
 	pf.Duration(ConfCrawlStats, time.Second, "Log: Crawl stats interval")
 
 	pf.Duration(ConfAllocStats, 10 * time.Second, "Log: Resource stats interval")
@@ -101,6 +162,12 @@ func prepareConfig() {
 
 	pf.String(ConfLogFile, "crawler.log", "Log file")
 
+	pf.String(ConfMetrics, "", "Log: Prometheus metrics listen address (e.g. localhost:9100)")
+
+	pf.String(ConfControl, "", "Control API listen address (e.g. localhost:9101)")
+
+	pf.Bool(ConfFileMetadata, false, "Output: Add Content-Type, ETag, Accept-Ranges, Content-Disposition name and Server to files")
+
 	// Bind all flags to Viper
 	pf.VisitAll(func(flag *pflag.Flag) {
 		s := flag.Name
@@ -166,14 +233,38 @@ func readConfig() {
 		configOOB(ConfChunkSize, config.ChunkSize)
 	}
 
-	config.Retries = viper.GetInt(ConfRetries)
-	if config.Retries < 0 {
-		config.Retries = 1 << 31
+	switch enc := viper.GetString(ConfUploadCompression); enc {
+	case "auto", "gzip", "zstd":
+		config.UploadCompression = enc
+	case "none", "":
+		config.UploadCompression = "none"
+	default:
+		configOOB(ConfUploadCompression, enc)
+	}
+
+	config.Crawl.Retries = viper.GetInt(ConfRetries)
+	if config.Crawl.Retries < 0 {
+		config.Crawl.Retries = 1 << 31
+	}
+
+	config.Crawl.Workers = viper.GetInt(ConfWorkers)
+	if config.Crawl.Workers <= 0 {
+		configOOB(ConfWorkers, config.Crawl.Workers)
+	}
+
+	config.Crawl.MaxWorkers = viper.GetInt(ConfMaxWorkers)
+	if config.Crawl.MaxWorkers < 0 {
+		configOOB(ConfMaxWorkers, config.Crawl.MaxWorkers)
 	}
 
-	config.Workers = viper.GetInt(ConfWorkers)
-	if config.Workers <= 0 {
-		configOOB(ConfWorkers, config.Workers)
+	config.Crawl.MaxHostConnections = viper.GetInt(ConfHostConnections)
+	if config.Crawl.MaxHostConnections < 0 {
+		configOOB(ConfHostConnections, config.Crawl.MaxHostConnections)
+	}
+
+	config.Crawl.MaxHostRate = viper.GetFloat64(ConfHostRate)
+	if config.Crawl.MaxHostRate < 0 {
+		configOOB(ConfHostRate, config.Crawl.MaxHostRate)
 	}
 
 	config.Tasks = viper.GetInt32(ConfTasks)
@@ -181,16 +272,81 @@ func readConfig() {
 		configOOB(ConfTasks, int(config.Tasks))
 	}
 
-	config.UserAgent = viper.GetString(ConfUserAgent)
+	config.Crawl.UserAgent = viper.GetString(ConfUserAgent)
+
+	config.Crawl.DialTimeout = viper.GetDuration(ConfDialTimeout)
+
+	config.Crawl.Timeout = viper.GetDuration(ConfTimeout)
+
+	config.Crawl.Proxy = readProxy(ConfProxy)
+
+	config.Crawl.TorProxy = readProxy(ConfTorProxy)
+
+	config.Crawl.JobBufferSize = viper.GetInt(ConfJobBufferSize)
+
+	switch backend := viper.GetString(ConfQueueBackend); backend {
+	case "goque", "":
+		config.Crawl.QueueBackend = crawler.QueueGoque
+	case "bolt":
+		config.Crawl.QueueBackend = crawler.QueueBolt
+	case "memory":
+		config.Crawl.QueueBackend = crawler.QueueMemory
+	default:
+		configOOB(ConfQueueBackend, backend)
+	}
+
+	config.Crawl.QueueDir = "queue"
+
+	switch trust := viper.GetString(ConfTrustListing); trust {
+	case "exact":
+		config.Crawl.TrustListing = crawler.ConfidenceExact
+	case "approx":
+		config.Crawl.TrustListing = crawler.ConfidenceApprox
+	case "off", "":
+		config.Crawl.TrustListing = crawler.ConfidenceNone
+	default:
+		configOOB(ConfTrustListing, trust)
+	}
 
-	setDialTimeout(viper.GetDuration(ConfDialTimeout))
+	config.Checkpoint = viper.GetDuration(ConfCheckpoint)
 
-	setTimeout(viper.GetDuration(ConfTimeout))
+	config.Crawl.MaxRedirects = viper.GetInt(ConfMaxRedirects)
 
-	config.JobBufferSize = viper.GetInt(ConfJobBufferSize)
+	switch policy := viper.GetString(ConfRedirectPolicy); policy {
+	case "same-host":
+		config.Crawl.RedirectPolicy = crawler.RedirectSameHost
+	case "same-prefix":
+		config.Crawl.RedirectPolicy = crawler.RedirectSamePrefix
+	case "any", "":
+		config.Crawl.RedirectPolicy = crawler.RedirectAny
+	default:
+		configOOB(ConfRedirectPolicy, policy)
+	}
 
-	config.Verbose = viper.GetBool(ConfVerbose)
-	if config.Verbose {
+	config.Crawl.MaxDepth = viper.GetInt(ConfMaxDepth)
+	config.Crawl.MaxPathLength = viper.GetInt(ConfMaxPathLength)
+	config.Crawl.MaxRepeats = viper.GetInt(ConfMaxRepeats)
+	maxFiles := viper.GetInt64(ConfMaxFiles)
+	if maxFiles < 0 {
+		configOOB(ConfMaxFiles, maxFiles)
+	}
+	config.Crawl.MaxFiles = uint64(maxFiles)
+	maxDirs := viper.GetInt64(ConfMaxDirs)
+	if maxDirs < 0 {
+		configOOB(ConfMaxDirs, maxDirs)
+	}
+	config.Crawl.MaxDirs = uint64(maxDirs)
+	config.Crawl.MaxDuration = viper.GetDuration(ConfMaxDuration)
+
+	config.Crawl.MinListingScore = viper.GetFloat64(ConfMinListingScore)
+	if config.Crawl.MinListingScore < 0 || config.Crawl.MinListingScore > 1 {
+		configOOB(ConfMinListingScore, config.Crawl.MinListingScore)
+	}
+
+	config.Crawl.Robots = viper.GetBool(ConfRobots)
+
+	config.Crawl.Verbose = viper.GetBool(ConfVerbose)
+	if config.Crawl.Verbose {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
 
@@ -205,7 +361,18 @@ func readConfig() {
 		logrus.SetOutput(io.MultiWriter(os.Stdout, bufWriter))
 	}
 
-	config.PrintHTTP = viper.GetBool(ConfPrintHTTP)
+	config.Crawl.PrintHTTP = viper.GetBool(ConfPrintHTTP)
+
+	config.MetricsListen = viper.GetString(ConfMetrics)
+	if config.MetricsListen != "" {
+		config.Crawl.OnRequest = observeRequest
+	}
+
+	config.ControlListen = viper.GetString(ConfControl)
+
+	config.Crawl.FileMetadata = viper.GetBool(ConfFileMetadata)
+
+	engine = crawler.New(config.Crawl)
 }
 
 func configMissing(key string) {
@@ -213,6 +380,21 @@ func configMissing(key string) {
 	os.Exit(1)
 }
 
+// readProxy parses a proxy URL, nil if unset
+func readProxy(key string) *crawler.Proxy {
+	rawUrl := viper.GetString(key)
+	if rawUrl == "" {
+		return nil
+	}
+	p, err := crawler.ParseProxy(rawUrl)
+	if err != nil {
+		// Don't print the URL, it might contain a password
+		fmt.Fprintf(os.Stderr, "config: illegal proxy for key %s: %s\n", key, err)
+		os.Exit(1)
+	}
+	return p
+}
+
 func configOOB(key string, v interface{}) {
 	fmt.Fprintf(os.Stderr, "config: illegal value %v for key %s!\n", v, key)
 	os.Exit(1)
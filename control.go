@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"github.com/terorie/od-database-crawler/crawler"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type TaskStatus struct {
+	WebsiteId   uint64    `json:"website_id"`
+	Url         string    `json:"url"`
+	State       string    `json:"state"`
+	StartTime   time.Time `json:"start_time"`
+	FileCount   uint64    `json:"file_count"`
+	ErrorCount  uint64    `json:"error_count"`
+	QueueLength int64     `json:"queue_length"`
+	// Jobs per second
+	Rate        float64   `json:"rate"`
+	// Current connections, see crawl.max_connections
+	Workers     int       `json:"workers"`
+}
+
+func (o *Remote) Status() TaskStatus {
+	state := "running"
+	if o.Paused() {
+		state = "paused"
+	}
+	switch o.StopMode() {
+	case crawler.StopFinish:
+		state = "finishing"
+	case crawler.StopCancel:
+		state = "canceling"
+	}
+	return TaskStatus{
+		WebsiteId:   o.Task.WebsiteId,
+		Url:         o.BaseUri.String(),
+		State:       state,
+		StartTime:   o.Result.StartTime,
+		FileCount:   atomic.LoadUint64(&o.Result.FileCount),
+		ErrorCount:  atomic.LoadUint64(&o.Result.ErrorCount),
+		QueueLength: o.Pending(),
+		Rate:        o.Rate(),
+		Workers:     o.Workers(),
+	}
+}
+
+// ActiveTasks returns the status of all tasks
+func ActiveTasks() (tasks []TaskStatus) {
+	activeTasksLock.Lock()
+	for _, od := range activeTasks {
+		tasks = append(tasks, od.Status())
+	}
+	activeTasksLock.Unlock()
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].WebsiteId < tasks[j].WebsiteId
+	})
+	return
+}
+
+func findTask(websiteId uint64) *Remote {
+	activeTasksLock.Lock()
+	defer activeTasksLock.Unlock()
+	return activeTasks[websiteId]
+}
+
+// serveTasks handles the control API:
+//   GET  /tasks
+//   GET  /tasks/<id>
+//   POST /tasks/<id>/pause
+//   POST /tasks/<id>/resume
+//   POST /tasks/<id>/cancel
+//   POST /tasks/<id>/finish
+func serveTasks(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		tasks := ActiveTasks()
+		if tasks == nil {
+			tasks = []TaskStatus{}
+		}
+		writeJson(w, tasks)
+		return
+	}
+
+	websiteId, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+	od := findTask(websiteId)
+	if od == nil {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+
+	if len(parts) == 2 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJson(w, od.Status())
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var ok bool
+	switch action := parts[2]; action {
+	case "pause":
+		ok = od.Pause()
+	case "resume":
+		ok = od.Resume()
+	case "cancel":
+		ok = od.Stop(crawler.StopCancel)
+	case "finish":
+		ok = od.Stop(crawler.StopFinish)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if !ok {
+		http.Error(w, "not possible in state " + od.Status().State,
+			http.StatusConflict)
+		return
+	}
+
+	logrus.WithField("id", websiteId).
+		Infof("Task %s via control API", parts[2])
+	writeJson(w, od.Status())
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.WithError(err).
+			Error("Failed to write control API response")
+	}
+}
+
+// ListenLocal starts the metrics and
+// control endpoints if configured.
+func ListenLocal() {
+	muxes := make(map[string]*http.ServeMux)
+	getMux := func(addr string) *http.ServeMux {
+		mux := muxes[addr]
+		if mux == nil {
+			mux = http.NewServeMux()
+			muxes[addr] = mux
+		}
+		return mux
+	}
+
+	if config.MetricsListen != "" {
+		getMux(config.MetricsListen).HandleFunc("/metrics", serveMetrics)
+	}
+	if config.ControlListen != "" {
+		mux := getMux(config.ControlListen)
+		mux.HandleFunc("/tasks", serveTasks)
+		mux.HandleFunc("/tasks/", serveTasks)
+	}
+
+	for addr, mux := range muxes {
+		go func(addr string, mux *http.ServeMux) {
+			err := http.ListenAndServe(addr, mux)
+			logrus.WithError(err).
+				WithField("addr", addr).
+				Error("Local HTTP endpoint failed")
+		}(addr, mux)
+	}
+}
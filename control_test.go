@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/terorie/od-database-crawler/crawler"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func controlTestRequest(t *testing.T, method, path string, status int, v interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	rec := httptest.NewRecorder()
+	serveTasks(rec, req)
+	if rec.Code != status {
+		t.Fatalf("%s %s: expected status %d, got %d: %s",
+			method, path, status, rec.Code, rec.Body.String())
+	}
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestControlAPI(t *testing.T) {
+	var u fasturl.URL
+	if err := u.Parse("http://example.org/pub/"); err != nil {
+		t.Fatal(err)
+	}
+	od := newRemote(&crawler.Task{WebsiteId: 1337, Url: "http://example.org/pub/"}, &u)
+	if !od.register() {
+		t.Fatal("Task already registered")
+	}
+	defer od.unregister()
+	od.Result.FileCount = 12
+
+	var tasks []TaskStatus
+	controlTestRequest(t, "GET", "/tasks", http.StatusOK, &tasks)
+	if len(tasks) != 1 || tasks[0].WebsiteId != 1337 ||
+		tasks[0].FileCount != 12 || tasks[0].State != "running" {
+		t.Errorf("Unexpected task list %+v", tasks)
+	}
+
+	var status TaskStatus
+	controlTestRequest(t, "POST", "/tasks/1337/pause", http.StatusOK, &status)
+	if status.State != "paused" {
+		t.Errorf("Expected paused task, got %s", status.State)
+	}
+	controlTestRequest(t, "POST", "/tasks/1337/pause", http.StatusConflict, nil)
+	controlTestRequest(t, "POST", "/tasks/1337/resume", http.StatusOK, &status)
+	if status.State != "running" {
+		t.Errorf("Expected running task, got %s", status.State)
+	}
+
+	od.Pause()
+	controlTestRequest(t, "POST", "/tasks/1337/finish", http.StatusOK, &status)
+	if status.State != "finishing" || od.Paused() {
+		t.Errorf("Expected finishing task, got %s", status.State)
+	}
+	controlTestRequest(t, "POST", "/tasks/1337/cancel", http.StatusConflict, nil)
+
+	controlTestRequest(t, "GET", "/tasks/1", http.StatusNotFound, nil)
+	controlTestRequest(t, "GET", "/tasks/1337/pause", http.StatusMethodNotAllowed, nil)
+	controlTestRequest(t, "POST", "/tasks/1337/explode", http.StatusNotFound, nil)
+}
@@ -0,0 +1,186 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"github.com/terorie/od-database-crawler/dates"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Machine-readable listings of nginx (autoindex_format json/xml)
+// and Caddy (browse with "Accept: application/json") carry exact
+// names, sizes and dates, so no HTML has to be scraped.
+
+// Sent with listing requests. HTML is preferred, servers without
+// content negotiation ignore it. Caddy answers with JSON.
+const listingAccept = "text/html, application/json;q=0.9, */*;q=0.8"
+
+// Entry of a JSON listing. encoding/json matches
+// keys case-insensitively, so Caddy 1 and 2 and
+// nginx all fit into this struct.
+type jsonListingEntry struct {
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	Size      *int64 `json:"size"`
+	// nginx: "directory", "file" or "other"
+	Type      string `json:"type"`
+	// nginx, RFC 1123
+	MTime     string `json:"mtime"`
+	// Caddy 2, RFC 3339
+	ModTime   string `json:"mod_time"`
+	IsDir     bool   `json:"is_dir"`
+	// Caddy 1
+	ModTimeV1 string `json:"ModTime"`
+	IsDirV1   bool   `json:"IsDir"`
+}
+
+type xmlListing struct {
+	Entries []xmlListingEntry `xml:",any"`
+}
+
+// <file mtime="2018-09-05T12:00:00Z" size="1234">name</file>
+type xmlListingEntry struct {
+	XMLName xml.Name
+	MTime   string `xml:"mtime,attr"`
+	Size    string `xml:"size,attr"`
+	Name    string `xml:",chardata"`
+}
+
+// ParseListing parses a listing in the format given by the
+// Content-Type. Falls back to HTML for unknown types or if
+// a machine-readable listing can't be read.
+func ParseListing(contentType string, body []byte, baseUri *fasturl.URL) ([]DirEntry, error) {
+	format := FingerprintListing("", contentType, body)
+	return ParseListingFormat(contentType, body, baseUri, format)
+}
+
+// ParseListingFormat is ParseListing with the
+// HTML parser of an already known format.
+func ParseListingFormat(contentType string, body []byte, baseUri *fasturl.URL, format ListingFormat) ([]DirEntry, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/json":
+		if entries, err := ParseDirJSON(body, baseUri); err == nil {
+			return entries, nil
+		}
+	case "application/xml", "text/xml":
+		if bytes.Contains(body, []byte("<list")) {
+			if entries, err := ParseDirXML(body, baseUri); err == nil {
+				return entries, nil
+			}
+		}
+	}
+	return ParseDirFormat(body, baseUri, format)
+}
+
+// ParseDirJSON reads nginx and Caddy JSON listings.
+func ParseDirJSON(body []byte, baseUri *fasturl.URL) (entries []DirEntry, err error) {
+	var list []jsonListingEntry
+	if err = json.Unmarshal(body, &list); err != nil {
+		return nil, err
+	}
+
+	for _, item := range list {
+		isDir := item.IsDir || item.IsDirV1 || item.Type == "directory"
+		if item.Type == "other" {
+			continue
+		}
+
+		href := item.URL
+		if href == "" {
+			href = listingHref(item.Name, isDir)
+		}
+		entry, ok := machineEntry(baseUri, href, item.Name, isDir)
+		if !ok {
+			continue
+		}
+
+		if !isDir && item.Size != nil && *item.Size >= 0 {
+			entry.Size = *item.Size
+		}
+		for _, v := range []string{item.MTime, item.ModTime, item.ModTimeV1} {
+			if t, conf := dates.Parse(v); conf != dates.Invalid {
+				entry.MTime = t.Unix()
+				break
+			}
+		}
+		entry.setConfidence()
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ParseDirXML reads nginx XML listings.
+func ParseDirXML(body []byte, baseUri *fasturl.URL) (entries []DirEntry, err error) {
+	var list xmlListing
+	if err = xml.Unmarshal(body, &list); err != nil {
+		return nil, err
+	}
+
+	for _, item := range list.Entries {
+		var isDir bool
+		switch item.XMLName.Local {
+		case "directory":
+			isDir = true
+		case "file":
+		default:
+			continue
+		}
+
+		entry, ok := machineEntry(baseUri, listingHref(item.Name, isDir), item.Name, isDir)
+		if !ok {
+			continue
+		}
+
+		if size, err := strconv.ParseInt(item.Size, 10, 64); err == nil && !isDir && size >= 0 {
+			entry.Size = size
+		}
+		if t, conf := dates.Parse(item.MTime); conf != dates.Invalid {
+			entry.MTime = t.Unix()
+		}
+		entry.setConfidence()
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func machineEntry(baseUri *fasturl.URL, href, name string, isDir bool) (entry DirEntry, ok bool) {
+	switch name {
+	case "", ".", "..":
+		return entry, false
+	}
+	link, ok := resolveEntry(baseUri, href)
+	if !ok {
+		return entry, false
+	}
+	if isDir && !strings.HasSuffix(link.Path, "/") {
+		link.Path += "/"
+	}
+	return DirEntry{
+		Link:  link,
+		Href:  href,
+		Name:  name,
+		IsDir: isDir,
+		Size:  -1,
+	}, true
+}
+
+// Relative link to a name in the listed directory
+func listingHref(name string, isDir bool) string {
+	href := "./" + url.PathEscape(name)
+	if isDir {
+		href += "/"
+	}
+	return href
+}
+
+func (e *DirEntry) setConfidence() {
+	if !e.IsDir && e.Size >= 0 && e.MTime != 0 {
+		e.Confidence = ConfidenceExact
+	}
+}
@@ -0,0 +1,191 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var boltBucket = []byte("jobs")
+
+// boltQueue stores jobs in a single bbolt file,
+// keyed by an increasing sequence number
+type boltQueue struct {
+	db *bolt.DB
+	m  sync.Mutex
+	// Number of jobs
+	n    int
+	// Jobs dequeued but not acked and the key of the last one
+	read int
+	last []byte
+}
+
+func openBoltQueue(dir string) (*boltQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(filepath.Join(dir, "queue.db"), 0644, &bolt.Options{
+		Timeout: time.Second,
+		// Only synced on Close, a power
+		// loss can lose queued jobs
+		NoSync:         true,
+		NoFreelistSync: true,
+		FreelistType:   bolt.FreelistMapType,
+	})
+	if err != nil { return nil, err }
+
+	q := &boltQueue{db: db}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(boltBucket)
+		if err != nil { return err }
+		q.n = b.Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *boltQueue) Enqueue(job *Job) error {
+	var gob JobGob
+	gob.ToGob(job)
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		seq, err := b.NextSequence()
+		if err != nil { return err }
+		var key [8]byte
+		binary.BigEndian.PutUint64(key[:], seq)
+		return b.Put(key[:], encodeJob(&gob))
+	})
+	if err != nil { return boltError(err) }
+	q.m.Lock()
+	q.n++
+	q.m.Unlock()
+	return nil
+}
+
+func (q *boltQueue) Dequeue(job *Job) error {
+	q.m.Lock()
+	defer q.m.Unlock()
+	var gob JobGob
+	err := q.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		var k, v []byte
+		if q.last == nil {
+			k, v = c.First()
+		} else if k, v = c.Seek(q.last); bytes.Equal(k, q.last) {
+			k, v = c.Next()
+		}
+		if k == nil {
+			return ErrQueueEmpty
+		}
+		if err := decodeJob(v, &gob); err != nil {
+			return err
+		}
+		q.last = append(q.last[:0], k...)
+		return nil
+	})
+	if err != nil { return boltError(err) }
+	q.read++
+	gob.FromGob(job)
+	return nil
+}
+
+func (q *boltQueue) Ack() error {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if q.read == 0 {
+		return nil
+	}
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, q.last) <= 0; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil { return boltError(err) }
+	q.n -= q.read
+	q.read = 0
+	return nil
+}
+
+func (q *boltQueue) Len() int {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.n - q.read
+}
+
+func (q *boltQueue) Close() error {
+	if err := q.db.Sync(); err != nil {
+		return boltError(err)
+	}
+	return q.db.Close()
+}
+
+func boltError(err error) error {
+	if err == bolt.ErrDatabaseNotOpen {
+		return ErrQueueClosed
+	}
+	return err
+}
+
+var errBadJob = errors.New("malformed job in queue")
+
+// encodeJob serializes a job as length-prefixed URL
+// and error, the number of fails and the generation
+func encodeJob(g *JobGob) []byte {
+	buf := make([]byte, 0, len(g.Uri) + len(g.LastError) + 4 * binary.MaxVarintLen64)
+	var tmp [binary.MaxVarintLen64]byte
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(g.Uri)))]...)
+	buf = append(buf, g.Uri...)
+	buf = append(buf, tmp[:binary.PutVarint(tmp[:], int64(g.Fails))]...)
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(g.LastError)))]...)
+	buf = append(buf, g.LastError...)
+	buf = append(buf, tmp[:binary.PutVarint(tmp[:], int64(g.Gen))]...)
+	return buf
+}
+
+func decodeJob(buf []byte, g *JobGob) error {
+	readString := func() (string, bool) {
+		n, i := binary.Uvarint(buf)
+		if i <= 0 || uint64(len(buf) - i) < n {
+			return "", false
+		}
+		s := string(buf[i:i+int(n)])
+		buf = buf[i+int(n):]
+		return s, true
+	}
+
+	var ok bool
+	if g.Uri, ok = readString(); !ok {
+		return errBadJob
+	}
+	fails, i := binary.Varint(buf)
+	if i <= 0 {
+		return errBadJob
+	}
+	g.Fails = int(fails)
+	buf = buf[i:]
+	if g.LastError, ok = readString(); !ok {
+		return errBadJob
+	}
+	// Missing in older queues
+	if len(buf) > 0 {
+		gen, i := binary.Varint(buf)
+		if i <= 0 {
+			return errBadJob
+		}
+		g.Gen = int(gen)
+	}
+	return nil
+}
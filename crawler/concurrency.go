@@ -0,0 +1,152 @@
+package crawler
+
+import (
+	"github.com/valyala/fasthttp"
+	"net"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
+// With Options.MaxWorkers above Options.Workers, the number of
+// busy workers of a task adapts to the site (AIMD): it grows by
+// one after each round of healthy requests and halves on
+// timeouts, rate limits and server errors.
+
+const (
+	// Rounds slower than this many times the
+	// baseline latency don't speed up the crawl
+	latencyTolerance = 2
+	// Time after a decrease before the next one,
+	// concurrent failures only count once
+	concurrencyCooldown = time.Second
+)
+
+// concurrencyLimit hands out slots to the workers of a task
+type concurrencyLimit struct {
+	m        sync.Mutex
+	cond     *sync.Cond
+	adaptive bool
+	// Current and highest number of busy workers
+	limit    int
+	max      int
+	busy     int
+	// Current round, one request per slot
+	requests int
+	errors   int
+	latency  time.Duration
+	// Average latency of healthy rounds
+	baseline time.Duration
+	// Time of the last decrease
+	decreased time.Time
+}
+
+func (l *concurrencyLimit) init(workers, maxWorkers int) {
+	l.cond = sync.NewCond(&l.m)
+	l.limit = workers
+	l.max = workers
+	if maxWorkers > workers {
+		l.adaptive = true
+		l.max = maxWorkers
+	}
+}
+
+// acquire blocks until less than limit workers are busy
+func (l *concurrencyLimit) acquire() {
+	l.m.Lock()
+	for l.busy >= l.limit {
+		l.cond.Wait()
+	}
+	l.busy++
+	l.m.Unlock()
+}
+
+func (l *concurrencyLimit) release() {
+	l.m.Lock()
+	l.busy--
+	l.m.Unlock()
+	l.cond.Signal()
+}
+
+// Limit returns the current number of workers
+func (l *concurrencyLimit) Limit() int {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.limit
+}
+
+// observe adjusts the limit after a job
+// that took d and failed with err, if any.
+func (l *concurrencyLimit) observe(d time.Duration, err error) {
+	if !l.adaptive {
+		return
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if isOverload(err) {
+		now := time.Now()
+		if now.Sub(l.decreased) < concurrencyCooldown {
+			return
+		}
+		l.limit /= 2
+		if l.limit < 1 {
+			l.limit = 1
+		}
+		l.decreased = now
+		l.newRound()
+		return
+	}
+
+	l.requests++
+	l.latency += d
+	if err != nil {
+		l.errors++
+	}
+	if l.requests < l.limit {
+		return
+	}
+
+	// Round complete
+	avg := l.latency / time.Duration(l.requests)
+	healthy := l.errors * 10 <= l.requests &&
+		(l.baseline == 0 || avg <= l.baseline * latencyTolerance)
+	if healthy {
+		if l.baseline == 0 || avg < l.baseline {
+			l.baseline = avg
+		} else {
+			// Follow a site that got slower for good
+			l.baseline += (avg - l.baseline) / 8
+		}
+		if l.limit < l.max {
+			l.limit++
+			l.cond.Signal()
+		}
+	}
+	l.newRound()
+}
+
+func (l *concurrencyLimit) newRound() {
+	l.requests = 0
+	l.errors = 0
+	l.latency = 0
+}
+
+// isOverload checks if err means the site
+// can't keep up: timeouts, rate limits, 5xx
+// and FTP servers out of connections
+func isOverload(err error) bool {
+	switch err := err.(type) {
+	case nil:
+		return false
+	case *RateLimitError:
+		return true
+	case *HttpError:
+		return err.Code >= 500
+	case *textproto.Error:
+		return err.Code == 421
+	case net.Error:
+		return err.Timeout()
+	}
+	return err == fasthttp.ErrTimeout
+}
@@ -0,0 +1,141 @@
+package crawler
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	var l concurrencyLimit
+	l.init(1, 4)
+
+	// One round per slot
+	for i := 1; i <= 3; i++ {
+		for j := 0; j < i; j++ {
+			l.observe(10 * time.Millisecond, nil)
+		}
+		if l.Limit() != i + 1 {
+			t.Fatalf("Expected %d workers after healthy round, got %d", i + 1, l.Limit())
+		}
+	}
+	// Ceiling
+	for i := 0; i < 8; i++ {
+		l.observe(10 * time.Millisecond, nil)
+	}
+	if l.Limit() != 4 {
+		t.Fatalf("Expected 4 workers, got %d", l.Limit())
+	}
+
+	// Slow round
+	for i := 0; i < 4; i++ {
+		l.observe(50 * time.Millisecond, nil)
+	}
+	if l.Limit() != 4 {
+		t.Errorf("Expected slow round to keep 4 workers, got %d", l.Limit())
+	}
+
+	// Concurrent failures only count once
+	l.observe(0, &RateLimitError{code: 429})
+	l.observe(0, &HttpError{Code: 502})
+	if l.Limit() != 2 {
+		t.Errorf("Expected 2 workers after rate limit, got %d", l.Limit())
+	}
+	l.decreased = time.Time{}
+	l.observe(0, &HttpError{Code: 503})
+	l.decreased = time.Time{}
+	l.observe(0, &HttpError{Code: 500})
+	if l.Limit() != 1 {
+		t.Errorf("Expected at least one worker, got %d", l.Limit())
+	}
+
+	// Client errors are no reason to slow down, but
+	// too many of them are no reason to speed up
+	l.observe(10 * time.Millisecond, &HttpError{Code: 404})
+	if l.Limit() != 1 {
+		t.Errorf("Expected 1 worker after failed round, got %d", l.Limit())
+	}
+}
+
+func TestConcurrencyLimitFixed(t *testing.T) {
+	var l concurrencyLimit
+	l.init(2, 0)
+	for i := 0; i < 10; i++ {
+		l.observe(time.Millisecond, nil)
+	}
+	l.observe(0, &RateLimitError{code: 429})
+	if l.Limit() != 2 {
+		t.Errorf("Expected fixed 2 workers, got %d", l.Limit())
+	}
+}
+
+func TestConcurrencyLimitAcquire(t *testing.T) {
+	var l concurrencyLimit
+	l.init(1, 2)
+	l.acquire()
+
+	var acquired int32
+	go func() {
+		l.acquire()
+		atomic.StoreInt32(&acquired, 1)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt32(&acquired) != 0 {
+		t.Fatal("Acquired more slots than the limit")
+	}
+
+	// Growing the limit wakes up a worker
+	l.observe(time.Millisecond, nil)
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&acquired) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Worker not woken up")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestIsOverload(t *testing.T) {
+	tests := []struct {
+		err      error
+		overload bool
+	}{
+		{nil, false},
+		{&RateLimitError{code: 429}, true},
+		{&HttpError{Code: 500}, true},
+		{&HttpError{Code: 404}, false},
+		{&timeoutError{}, true},
+		{errors.New("connection reset"), false},
+	}
+	for _, test := range tests {
+		if isOverload(test.err) != test.overload {
+			t.Errorf("isOverload(%v): expected %v", test.err, test.overload)
+		}
+	}
+}
+
+func TestCrawlAdaptiveWorkers(t *testing.T) {
+	var maxActive int32
+	s := newBusyServer(20, &maxActive)
+	defer s.Close()
+
+	c := New(Options{
+		Workers:       1,
+		MaxWorkers:    4,
+		JobBufferSize: -1,
+	})
+	result, _ := crawlTest(t, c, Task{WebsiteId: 1, Url: s.URL + "/pub/"})
+	if result.FileCount != 20 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if max := atomic.LoadInt32(&maxActive); max < 2 || max > 4 {
+		t.Errorf("Expected 2 to 4 connections, got %d", max)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
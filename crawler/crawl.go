@@ -0,0 +1,220 @@
+package crawler
+
+import (
+	"github.com/terorie/od-database-crawler/dates"
+	"github.com/terorie/od-database-crawler/ds/redblackhash"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/crypto/blake2b"
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GetDir lists a directory. The format of the listing
+// is fingerprinted once per site.
+func (o *OD) GetDir(j *Job, f *File) (entries []DirEntry, err error) {
+	c := o.crawler
+	base, site := &o.BaseUri, &o.Format
+	f.IsDir = true
+	f.Name = path.Base(j.Uri.Path)
+
+	req := fasthttp.AcquireRequest()
+	if c.opts.UserAgent != "" {
+		req.Header.SetUserAgent(c.opts.UserAgent)
+	}
+	req.Header.Set("Accept", listingAccept)
+
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+
+	final, err := o.doRequest(req, res, j.Uri, base)
+	fasthttp.ReleaseRequest(req)
+
+	if err != nil {
+		return
+	}
+
+	if o.probeListing(res) {
+		return nil, errRelist
+	}
+
+	err = checkResponse(res)
+	if err != nil {
+		return
+	}
+
+	// Don't leave the directory tree
+	if !strings.HasSuffix(final.Path, "/") || !inScope(base, &final) {
+		return nil, ErrRedirectOutOfScope
+	}
+
+	body := res.Body()
+	contentType := string(res.Header.ContentType())
+	detect := func() ListingFormat {
+		return FingerprintListing(string(res.Header.Server()), contentType, body)
+	}
+	format := site.Detect(detect)
+	entries, err = ParseListingFormat(contentType, body, &final, format)
+	if err != nil {
+		return
+	}
+
+	if min := c.opts.MinListingScore; min > 0 && ListingScore(body, format, entries) < min {
+		return nil, ErrNotListing
+	}
+	return
+}
+
+// listDir lists a directory of an HTTP site,
+// with WebDAV or S3 if the server supports it.
+func (o *OD) listDir(j *Job, f *File) (links []fasturl.URL, files []File, err error) {
+	if bucket := o.S3(); bucket != nil {
+		o.Format.Detect(constFormat(FormatS3))
+		return o.GetDirS3(bucket, j, f)
+	}
+	if o.WebDAV() {
+		o.Format.Detect(constFormat(FormatWebDAV))
+		return o.GetDirDAV(j, f)
+	}
+	entries, err := o.GetDir(j, f)
+	if err == errRelist {
+		return o.listDir(j, f)
+	}
+	links, files = splitEntries(entries, o.crawler.opts.TrustListing)
+	return
+}
+
+// probeListing looks for WebDAV and S3 once per site, after the
+// first listing request. Only done if it didn't return an HTML
+// or JSON listing, or the headers point to such a server.
+// Returns true if one was found.
+func (o *OD) probeListing(res *fasthttp.Response) bool {
+	switch res.StatusCode() {
+	case fasthttp.StatusTooManyRequests, fasthttp.StatusServiceUnavailable:
+		// Decide on the next attempt
+		return false
+	}
+
+	o.probeM.Lock()
+	defer o.probeM.Unlock()
+	if o.probed {
+		return false
+	}
+	o.probed = true
+
+	mediaType, _, _ := mime.ParseMediaType(string(res.Header.ContentType()))
+	listing := mediaType == "text/html" || mediaType == "application/json"
+	if res.StatusCode() == fasthttp.StatusOK && listing &&
+		!davHeaders(&res.Header) && !s3Headers(&res.Header) {
+		return false
+	}
+
+	if o.s3 = o.DetectS3(&o.BaseUri); o.s3 == nil {
+		o.dav = o.DetectWebDAV(&o.BaseUri)
+	}
+	return o.s3 != nil || o.dav
+}
+
+// GetFile fills in the file info from a HEAD request, or from a
+// GET request on hosts that don't answer HEAD properly.
+// Returns the URL after redirects.
+func (o *OD) GetFile(u fasturl.URL, f *File) (final fasturl.URL, err error) {
+	c := o.crawler
+	f.IsDir = false
+	u.Path = path.Clean(u.Path)
+	f.Name = path.Base(u.Path)
+	f.Path = strings.Trim(path.Dir(u.Path), "/")
+
+	method, known := c.fileMethods.get(u.Host)
+	final, _, sized, err := o.statFile(method, u, f)
+	if known || !headFailed(err, sized) {
+		return final, err
+	}
+
+	// Try a ranged GET and remember what works
+	getFinal, used, getSized, getErr := o.statFile(methodRange, u, f)
+	switch {
+	case getErr != nil && err == nil:
+		// HEAD without size is better than nothing
+		return final, nil
+	case getErr != nil:
+		return getFinal, getErr
+	case getSized || err != nil:
+		c.fileMethods.set(u.Host, used)
+	default:
+		// No size either way, stick to HEAD
+		c.fileMethods.set(u.Host, methodHead)
+	}
+	return getFinal, nil
+}
+
+func (f *File) HashDir(links []fasturl.URL, files []File) (o redblackhash.Key) {
+	h, _ := blake2b.New256(nil)
+	h.Write([]byte(f.Name))
+	for _, link := range links {
+		fileName := path.Base(link.Path)
+		h.Write([]byte(fileName))
+	}
+	for _, file := range files {
+		h.Write([]byte(file.Name))
+	}
+	sum := h.Sum(nil)
+	copy(o[:redblackhash.KeySize], sum)
+	return
+}
+
+func (f *File) applyContentLength(v string) {
+	if v == "" {
+		return
+	}
+	size, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return
+	}
+	if size < 0 {
+		return
+	}
+	f.Size = size
+}
+
+func (f *File) applyLastModified(v string) {
+	if t, conf := dates.Parse(v); conf != dates.Invalid {
+		f.MTime = t.Unix()
+	}
+}
+
+func (f *File) applyMetadata(h *fasthttp.ResponseHeader) {
+	f.ContentType = string(h.Peek("Content-Type"))
+	f.ETag = string(h.Peek("ETag"))
+	f.AcceptRanges = strings.EqualFold(string(h.Peek("Accept-Ranges")), "bytes")
+	f.Server = string(h.Peek("Server"))
+	if v := h.Peek("Content-Disposition"); len(v) > 0 {
+		_, params, err := mime.ParseMediaType(string(v))
+		if err == nil {
+			// Only the name, not a path
+			f.DispositionName = path.Base(strings.Replace(params["filename"], "\\", "/", -1))
+			if f.DispositionName == "." || f.DispositionName == "/" {
+				f.DispositionName = ""
+			}
+		}
+	}
+}
+
+func checkResponse(res *fasthttp.Response) error {
+	switch status := res.StatusCode(); status {
+	case fasthttp.StatusOK:
+		return nil
+	case fasthttp.StatusTooManyRequests,
+		fasthttp.StatusServiceUnavailable:
+		return &RateLimitError{
+			code:       status,
+			RetryAfter: parseRetryAfter(
+				string(res.Header.Peek("Retry-After")), time.Now()),
+		}
+	default:
+		return &HttpError{Code: status}
+	}
+}
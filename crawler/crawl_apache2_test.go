@@ -1,4 +1,4 @@
-package main
+package crawler
 
 import (
 	"github.com/terorie/od-database-crawler/fasturl"
@@ -12,18 +12,18 @@ func TestParseDirApache2(t *testing.T) {
 		t.Fatal("Failed to parse URL", err)
 	}
 
-	links, err := ParseDir([]byte(apache2Listing), &u)
+	entries, err := ParseDir([]byte(apache2Listing), &u)
 	if err != nil {
 		t.Fatal("Failed to extract links", err)
 	}
 
-	if len(links) != len(apache2Links) {
+	if len(entries) != len(apache2Links) {
 		t.Fatalf("Expected %d links, got %d",
-			len(apache2Links), len(links))
+			len(apache2Links), len(entries))
 	}
 
-	for i := 0; i < len(links); i++ {
-		gotLink := links[i].String()
+	for i := 0; i < len(entries); i++ {
+		gotLink := entries[i].Link.String()
 		expLink := apache2Links[i]
 
 		if gotLink != expLink {
@@ -33,6 +33,32 @@ func TestParseDirApache2(t *testing.T) {
 	}
 }
 
+func TestParseDirApache2Meta(t *testing.T) {
+	var u fasturl.URL
+	err := u.Parse("http://archive.ubuntu.com/ubuntu/indices/")
+	if err != nil {
+		t.Fatal("Failed to parse URL", err)
+	}
+
+	entries, err := ParseDir([]byte(apache2Listing), &u)
+	if err != nil {
+		t.Fatal("Failed to extract links", err)
+	}
+
+	if format := DetectListingFormat([]byte(apache2Listing)); format != FormatApache {
+		t.Errorf("Expected format apache, got %s", format)
+	}
+
+	checkDirEntries(t, entries, []DirEntry {
+		{Name: "md5sums.gz", Size: 29 << 20,
+			MTime: 1312798980, Confidence: ConfidenceApprox},
+		{Name: "override.artful-backports.main", Size: 135,
+			MTime: 1532034000, Confidence: ConfidenceExact},
+		{Name: "override.artful-backports.extra.multiverse", Size: 0,
+			MTime: 1532033940, Confidence: ConfidenceExact},
+	})
+}
+
 var apache2Links = []string {
 	"http://archive.ubuntu.com/ubuntu/indices/md5sums.gz",
 	"http://archive.ubuntu.com/ubuntu/indices/override.artful-backports.extra.main",
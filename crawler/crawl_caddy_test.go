@@ -0,0 +1,105 @@
+package crawler
+
+import (
+	"github.com/terorie/od-database-crawler/fasturl"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseDirCaddyJSON(t *testing.T) {
+	var u fasturl.URL
+	if err := u.Parse("http://example.org/"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ParseListing("application/json", []byte(caddyJSONListing), &u)
+	if err != nil {
+		t.Fatal("Failed to extract links", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 links, got %d", len(entries))
+	}
+	if link := entries[0].Link.String(); link != "http://example.org/docs/" {
+		t.Errorf("Unexpected link %s", link)
+	}
+
+	checkDirEntries(t, entries, []DirEntry {
+		{Name: "docs", IsDir: true, Size: -1, MTime: 1516070412},
+		{Name: "kernel.tar.xz", Size: 104123597,
+			MTime: 1516070412, Confidence: ConfidenceExact},
+	})
+}
+
+func TestParseDirCaddyV1JSON(t *testing.T) {
+	var u fasturl.URL
+	if err := u.Parse("http://example.org/pub/"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ParseListing("application/json", []byte(caddyV1JSONListing), &u)
+	if err != nil {
+		t.Fatal("Failed to extract links", err)
+	}
+
+	checkDirEntries(t, entries, []DirEntry {
+		{Name: "docs", IsDir: true, Size: -1, MTime: 1516070412},
+		{Name: "kernel.tar.xz", Size: 104123597,
+			MTime: 1516070412, Confidence: ConfidenceExact},
+	})
+}
+
+func TestParseListingFallback(t *testing.T) {
+	var u fasturl.URL
+	if err := u.Parse("http://example.org/"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wrong Content-Type, still an HTML listing
+	entries, err := ParseListing("application/json", []byte(caddyListing), &u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDirEntries(t, entries, []DirEntry {
+		{Name: "song.flac", Size: 31457280,
+			MTime: 1546398245, Confidence: ConfidenceExact},
+	})
+}
+
+func TestGetDirNegotiation(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(caddyListing))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(caddyJSONListing))
+	}))
+	defer s.Close()
+
+	var u fasturl.URL
+	if err := u.Parse(s.URL + "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	var f File
+	o := New(Options{}).NewOD(&Task{Url: u.String()}, &u)
+	entries, err := o.GetDir(&Job{Uri: u}, &f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDirEntries(t, entries, []DirEntry {
+		{Name: "kernel.tar.xz", Size: 104123597,
+			MTime: 1516070412, Confidence: ConfidenceExact},
+	})
+}
+
+// browse with "Accept: application/json" (Caddy 2)
+const caddyJSONListing = `[{"name":"docs","size":4096,"url":"./docs/","mod_time":"2018-01-16T02:40:12Z","mode":2147484141,"is_dir":true,"is_symlink":false},` +
+	`{"name":"kernel.tar.xz","size":104123597,"url":"./kernel.tar.xz","mod_time":"2018-01-16T02:40:12Z","mode":420,"is_dir":false,"is_symlink":false}]`
+
+// Caddy 1
+const caddyV1JSONListing = `[{"IsDir":true,"Name":"docs","Size":4096,"URL":"./docs/","ModTime":"2018-01-16T03:40:12+01:00","Mode":2147484141},` +
+	`{"IsDir":false,"Name":"kernel.tar.xz","Size":104123597,"URL":"./kernel.tar.xz","ModTime":"2018-01-16T03:40:12+01:00","Mode":420}]`
@@ -1,4 +1,4 @@
-package main
+package crawler
 
 import (
 	"github.com/terorie/od-database-crawler/fasturl"
@@ -12,18 +12,18 @@ func TestParseDirNginx(t *testing.T) {
 		t.Fatal("Failed to parse URL", err)
 	}
 
-	links, err := ParseDir([]byte(nginxListing), &u)
+	entries, err := ParseDir([]byte(nginxListing), &u)
 	if err != nil {
 		t.Fatal("Failed to extract links", err)
 	}
 
-	if len(links) != len(nginxLinks) {
+	if len(entries) != len(nginxLinks) {
 		t.Fatalf("Expected %d links, got %d",
-			len(nginxLinks), len(links))
+			len(nginxLinks), len(entries))
 	}
 
-	for i := 0; i < len(links); i++ {
-		gotLink := links[i].String()
+	for i := 0; i < len(entries); i++ {
+		gotLink := entries[i].Link.String()
 		expLink := nginxLinks[i]
 
 		if gotLink != expLink {
@@ -33,6 +33,81 @@ func TestParseDirNginx(t *testing.T) {
 	}
 }
 
+func TestParseDirNginxMeta(t *testing.T) {
+	var u fasturl.URL
+	err := u.Parse("https://the-eye.eu/public/")
+	if err != nil {
+		t.Fatal("Failed to parse URL", err)
+	}
+
+	entries, err := ParseDir([]byte(nginxListing), &u)
+	if err != nil {
+		t.Fatal("Failed to extract links", err)
+	}
+
+	if format := DetectListingFormat([]byte(nginxListing)); format != FormatNginx {
+		t.Errorf("Expected format nginx, got %s", format)
+	}
+
+	checkDirEntries(t, entries, []DirEntry {
+		{Name: "AppleArchive/", IsDir: true, Size: -1},
+		{Name: "Rclone_for_Scrubs.pdf", Size: 315 << 10,
+			MTime: 1536067860, Confidence: ConfidenceApprox},
+		{Name: "xbox-scene_Aug2014.7z", Size: 1 << 30,
+			MTime: 1509059340, Confidence: ConfidenceApprox},
+	})
+}
+
+func TestParseDirNginxJSON(t *testing.T) {
+	var u fasturl.URL
+	if err := u.Parse("https://the-eye.eu/public/"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ParseListing("application/json", []byte(nginxJSONListing), &u)
+	if err != nil {
+		t.Fatal("Failed to extract links", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 links, got %d", len(entries))
+	}
+	if link := entries[1].Link.String(); link != "https://the-eye.eu/public/Operating%20Systems/" {
+		t.Errorf("Unexpected link %s", link)
+	}
+
+	checkDirEntries(t, entries, []DirEntry {
+		{Name: "AppleArchive", IsDir: true, Size: -1, MTime: 1509732820},
+		{Name: "Rclone_for_Scrubs.pdf", Size: 322206,
+			MTime: 1536067860, Confidence: ConfidenceExact},
+	})
+}
+
+func TestParseDirNginxXML(t *testing.T) {
+	var u fasturl.URL
+	if err := u.Parse("https://the-eye.eu/public/"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ParseListing("text/xml; charset=utf-8", []byte(nginxXMLListing), &u)
+	if err != nil {
+		t.Fatal("Failed to extract links", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 links, got %d", len(entries))
+	}
+	if link := entries[2].Link.String(); link != "https://the-eye.eu/public/Rock%20&%20Roll.flac" {
+		t.Errorf("Unexpected link %s", link)
+	}
+
+	checkDirEntries(t, entries, []DirEntry {
+		{Name: "AppleArchive", IsDir: true, Size: -1, MTime: 1509732820},
+		{Name: "Rclone_for_Scrubs.pdf", Size: 322206,
+			MTime: 1536067860, Confidence: ConfidenceExact},
+		{Name: "Rock & Roll.flac", Size: 0,
+			MTime: 1536067860, Confidence: ConfidenceExact},
+	})
+}
+
 var nginxLinks = []string {
 	"https://the-eye.eu/public/AppleArchive/",
 	"https://the-eye.eu/public/AudioBooks/",
@@ -115,3 +190,20 @@ const nginxListing =
 <a href="xbox-scene_Aug2014.7z">xbox-scene_Aug2014.7z</a>                              26-Oct-2017 23:09      1G
 </pre><hr></body>
 </html>`
+
+// autoindex_format json;
+const nginxJSONListing = `[
+{ "name":"AppleArchive", "type":"directory", "mtime":"Fri, 03 Nov 2017 18:13:40 GMT" },
+{ "name":"Operating Systems", "type":"directory", "mtime":"Wed, 25 Apr 2018 07:18:00 GMT" },
+{ "name":"fifo", "type":"other", "mtime":"Wed, 25 Apr 2018 07:18:00 GMT" },
+{ "name":"Rclone_for_Scrubs.pdf", "type":"file", "mtime":"Tue, 04 Sep 2018 13:31:00 GMT", "size":322206 }
+]`
+
+// autoindex_format xml;
+const nginxXMLListing = `<?xml version="1.0"?>
+<list>
+<directory mtime="2017-11-03T18:13:40Z">AppleArchive</directory>
+<file mtime="2018-09-04T13:31:00Z" size="322206">Rclone_for_Scrubs.pdf</file>
+<file mtime="2018-09-04T13:31:00Z" size="0">Rock &amp; Roll.flac</file>
+<other mtime="2018-09-04T13:31:00Z">fifo</other>
+</list>`
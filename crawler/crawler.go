@@ -0,0 +1,250 @@
+// Package crawler crawls open directories over HTTP and FTP.
+//
+// A Crawler holds the settings and HTTP client shared by all
+// sites crawled with it. Each site is an OD, discovered files
+// are sent to a channel as they are found:
+//
+//	c := crawler.New(crawler.Options{Workers: 4})
+//	files := make(chan crawler.File)
+//	go func() {
+//		for f := range files {
+//			fmt.Println(f.Path, f.Name, f.Size)
+//		}
+//	}()
+//	result, err := c.Crawl(ctx, crawler.Task{Url: "http://example.org/pub/"}, files)
+//	close(files)
+package crawler
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/sirupsen/logrus"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"github.com/valyala/fasthttp"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Options configures a Crawler.
+// The zero value is usable, see New for the defaults.
+type Options struct {
+	// Connections per site (default 1)
+	Workers        int
+	// Adapt the connections per site to its latency
+	// and errors, up to MaxWorkers (0 = fixed at Workers)
+	MaxWorkers     int
+	// Limits per server (resolved IP) across all
+	// tasks on it, in connections and requests
+	// per second (0 = no limit)
+	MaxHostConnections int
+	MaxHostRate    float64
+	// Retries after a temporary failure
+	Retries        int
+	UserAgent      string
+	// TCP connect timeout (default 10s)
+	DialTimeout    time.Duration
+	// Request timeout (default 30s)
+	Timeout        time.Duration
+	// Jobs kept in memory per site, the rest is
+	// offloaded to QueueDir (-1 = memory only)
+	JobBufferSize  int
+	// Store of the offloaded jobs (default goque)
+	QueueBackend   QueueBackend
+	// Directory of the disk queues (default "queue")
+	QueueDir       string
+	// Use file info from listings instead of
+	// a HEAD request per file
+	TrustListing   Confidence
+	// Redirects to follow per request (0 = don't follow)
+	MaxRedirects   int
+	RedirectPolicy RedirectPolicy
+	// Proxy for all connections (nil = direct),
+	// tasks can bring their own, see Task.Proxy
+	Proxy          *Proxy
+	// Proxy for .onion hosts, usually Tor
+	TorProxy       *Proxy
+	// Trap limits, links exceeding them are skipped (0 = no limit).
+	// Depth is counted from the base URL, repeats are the
+	// occurrences of the same segment in a path.
+	MaxDepth       int
+	MaxPathLength  int
+	MaxRepeats     int
+	// Per-site limits, the crawl stops with the
+	// results found so far (0 = no limit)
+	MaxFiles       uint64
+	MaxDirs        uint64
+	MaxDuration    time.Duration
+	// Don't descend into pages with a lower ListingScore (0 = off)
+	MinListingScore float64
+	// Honor robots.txt and Crawl-delay
+	Robots         bool
+	// Keep Content-Type, ETag, Accept-Ranges, Content-Disposition
+	// and Server headers of requested files. Turns off TrustListing.
+	FileMetadata   bool
+	// Log every listed directory
+	Verbose        bool
+	// Log HTTP client errors
+	PrintHTTP      bool
+	// Defaults to the standard logrus logger
+	Log            logrus.FieldLogger
+
+	// Called after every request to a site
+	OnRequest func(r *RequestInfo)
+	// Called when a job is given up after failures
+	OnError   func(t *Task, j *Job, err error)
+}
+
+// RequestInfo describes a finished request to a site
+type RequestInfo struct {
+	Method   string
+	Status   int
+	Err      error
+	Duration time.Duration
+	// Bytes received
+	Received int
+}
+
+// Stats counts the jobs of all sites of a Crawler
+type Stats struct {
+	Started       uint64
+	Done          uint64
+	Retries       uint64
+	Aborted       uint64
+	RedirectLoops uint64
+	RateLimits    uint64
+	// Links skipped by the trap limits
+	Traps         uint64
+	// Jobs waiting in queues
+	Queued        int64
+}
+
+// Crawler crawls sites with shared settings.
+// It is safe for concurrent use.
+type Crawler struct {
+	opts   Options
+	log    logrus.FieldLogger
+	stats  Stats
+	// How to request file info per host
+	fileMethods fileMethods
+	// robots.txt by host
+	robots      robotsCache
+	// Limits per server
+	servers     serverLimiter
+}
+
+func New(opts Options) *Crawler {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.MaxWorkers < opts.Workers {
+		opts.MaxWorkers = opts.Workers
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 10 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if opts.QueueDir == "" {
+		opts.QueueDir = "queue"
+	}
+	if opts.Log == nil {
+		opts.Log = logrus.StandardLogger()
+	}
+	if opts.FileMetadata {
+		// Headers are only known for requested files
+		opts.TrustListing = ConfidenceNone
+	}
+
+	c := &Crawler{
+		opts: opts,
+		log:  opts.Log,
+	}
+	return c
+}
+
+// newClient returns an HTTP client dialing
+// through the proxies chosen by proxyForAddr
+func (c *Crawler) newClient(proxyForAddr func(addr string) (*Proxy, error)) *fasthttp.Client {
+	timeout := c.opts.DialTimeout
+	return &fasthttp.Client {
+		TLSConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+		Dial: func(addr string) (net.Conn, error) {
+			p, err := proxyForAddr(addr)
+			if err != nil {
+				return nil, err
+			}
+			if p != nil {
+				return p.Dial("tcp", addr, timeout)
+			}
+			return fasthttp.DialTimeout(addr, timeout)
+		},
+		ReadTimeout:  c.opts.Timeout,
+		WriteTimeout: c.opts.Timeout / 2,
+	}
+}
+
+// Options returns the settings of the crawler
+// with defaults filled in.
+func (c *Crawler) Options() Options {
+	return c.opts
+}
+
+// Stats returns the job counters of the crawler.
+func (c *Crawler) Stats() (s Stats) {
+	s.Started = atomic.LoadUint64(&c.stats.Started)
+	s.Done = atomic.LoadUint64(&c.stats.Done)
+	s.Retries = atomic.LoadUint64(&c.stats.Retries)
+	s.Aborted = atomic.LoadUint64(&c.stats.Aborted)
+	s.RedirectLoops = atomic.LoadUint64(&c.stats.RedirectLoops)
+	s.RateLimits = atomic.LoadUint64(&c.stats.RateLimits)
+	s.Traps = atomic.LoadUint64(&c.stats.Traps)
+	s.Queued = atomic.LoadInt64(&c.stats.Queued)
+	return
+}
+
+// Crawl crawls the site at t.Url and sends the files found to results.
+// Returns when the site is done or ctx is canceled. Remaining jobs
+// are dropped on cancellation and the context error is returned
+// along with the partial result.
+func (c *Crawler) Crawl(ctx context.Context, t Task, results chan<- File) (TaskResult, error) {
+	rawUrl := t.Url
+	// https://github.com/golang/go/issues/19779
+	if !strings.Contains(rawUrl, "://") {
+		rawUrl = "http://" + rawUrl
+	}
+	var u fasturl.URL
+	if err := u.Parse(rawUrl); err != nil {
+		return TaskResult{}, err
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	o := c.NewOD(&t, &u)
+	if err := o.Start(ctx, results); err != nil {
+		return TaskResult{}, err
+	}
+	o.Wait.Wait()
+	if err := o.Close(); err != nil {
+		return o.Result, err
+	}
+	return o.Result, ctx.Err()
+}
+
+func (c *Crawler) observeRequest(method string, err error, status int, start time.Time, received int) {
+	if c.opts.OnRequest == nil {
+		return
+	}
+	c.opts.OnRequest(&RequestInfo{
+		Method:   method,
+		Status:   status,
+		Err:      err,
+		Duration: time.Since(start),
+		Received: received,
+	})
+}
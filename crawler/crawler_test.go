@@ -0,0 +1,123 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync/atomic"
+	"testing"
+)
+
+func newCrawlTestServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pub/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body>
+<a href="../">Parent</a>
+<a href="a.bin">a.bin</a>
+<a href="sub/">sub/</a>
+<a href="missing.bin">missing.bin</a>
+</body></html>`))
+	})
+	mux.HandleFunc("/pub/sub/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body><a href="b.bin">b.bin</a></body></html>`))
+	})
+	mux.HandleFunc("/pub/a.bin", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+	})
+	mux.HandleFunc("/pub/sub/b.bin", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "200")
+	})
+	mux.HandleFunc("/pub/missing.bin", http.NotFound)
+	return httptest.NewServer(mux)
+}
+
+// crawlTestFiles runs a task to the end and returns the files found
+func crawlTestFiles(t *testing.T, c *Crawler, task Task) (TaskResult, []File) {
+	t.Helper()
+	var got []File
+	done := make(chan struct{})
+	files := make(chan File)
+	go func() {
+		for f := range files {
+			got = append(got, f)
+		}
+		close(done)
+	}()
+
+	result, err := c.Crawl(context.Background(), task, files)
+	close(files)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result, got
+}
+
+// crawlTest runs a task to the end and returns the paths of the files found
+func crawlTest(t *testing.T, c *Crawler, task Task) (TaskResult, []string) {
+	t.Helper()
+	result, files := crawlTestFiles(t, c, task)
+	var got []string
+	for _, f := range files {
+		got = append(got, f.Path + "/" + f.Name)
+	}
+	return result, got
+}
+
+func TestCrawl(t *testing.T) {
+	s := newCrawlTestServer()
+	defer s.Close()
+
+	var requests, failed int32
+	c := New(Options{
+		Workers:       2,
+		JobBufferSize: -1,
+		OnRequest: func(r *RequestInfo) {
+			atomic.AddInt32(&requests, 1)
+		},
+		OnError: func(task *Task, j *Job, err error) {
+			if j.Uri.Path != "/pub/missing.bin" {
+				t.Errorf("Unexpected error on %s: %s", j.UriStr, err)
+			}
+			atomic.AddInt32(&failed, 1)
+		},
+	})
+
+	result, got := crawlTest(t, c, Task{WebsiteId: 1, Url: s.URL + "/pub"})
+	sort.Strings(got)
+	if len(got) != 2 || got[0] != "pub/a.bin" || got[1] != "pub/sub/b.bin" {
+		t.Errorf("Unexpected files %v", got)
+	}
+	if result.StatusCode != "success" || result.FileCount != 2 || result.ErrorCount != 1 ||
+		result.ListingFormat != "generic" {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	// HTML listings aren't probed for WebDAV or S3
+	if failed != 1 || requests != 5 {
+		t.Errorf("Expected 1 error and 5 requests, got %d and %d", failed, requests)
+	}
+	if stats := c.Stats(); stats.Done != 4 || stats.Aborted != 1 || stats.Queued != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestCrawlCancel(t *testing.T) {
+	s := newCrawlTestServer()
+	defer s.Close()
+
+	c := New(Options{JobBufferSize: -1})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	files := make(chan File)
+	go func() {
+		for range files {}
+	}()
+	defer close(files)
+
+	_, err := c.Crawl(ctx, Task{Url: s.URL + "/pub/"}, files)
+	if err != context.Canceled {
+		t.Errorf("Expected cancellation, got %v", err)
+	}
+}
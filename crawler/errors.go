@@ -0,0 +1,70 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"time"
+)
+
+var ErrKnown      = errors.New("already crawled")
+var ErrNotListing = errors.New("not a directory listing")
+// Job dropped on purpose and counted in the TaskResult
+var errSkipped    = errors.New("skipped")
+// WebDAV or S3 found by probeListing, the directory is listed again
+var errRelist     = errors.New("listing method changed")
+
+type HttpError struct {
+	Code int
+}
+
+func (e HttpError) Error() string {
+	return fmt.Sprintf("http status %d", e.Code)
+}
+
+// RateLimitError is returned on HTTP 429 and 503
+type RateLimitError struct {
+	code       int
+	RetryAfter time.Duration
+}
+
+func (e RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited (http status %d)", e.code)
+}
+
+func shouldRetry(err error) bool {
+	switch err {
+	case ErrRedirectLoop, ErrTooManyRedirects, ErrRedirectOutOfScope, ErrNotListing,
+		ErrNoTorProxy:
+		return false
+	}
+
+	if _, ok := err.(*RateLimitError); ok {
+		return true
+	}
+
+	// Don't retry HTTP error codes
+	if _, ok := err.(*HttpError); ok {
+		return false
+	}
+
+	// FTP replies
+	if protoErr, ok := err.(*textproto.Error); ok {
+		// 4xx are transient, 5xx permanent
+		return protoErr.Code < 500
+	}
+
+	if dnsError, ok := err.(*net.DNSError); ok {
+		// Don't retry permanent DNS errors
+		return dnsError.IsTemporary
+	}
+
+	if netErr, ok := err.(*net.OpError); ok {
+		// Don't retry permanent network errors
+		return netErr.Temporary()
+	}
+
+	// Retry by default
+	return true
+}
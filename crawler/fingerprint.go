@@ -0,0 +1,91 @@
+package crawler
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+	"sync"
+)
+
+// SiteFormat remembers the listing format of a site.
+// It is detected on the first listing and the parser
+// of that format is used for the rest of the crawl.
+type SiteFormat struct {
+	once   sync.Once
+	format ListingFormat
+	known  bool
+}
+
+// Detect returns the format of the site,
+// calling detect if it isn't known yet.
+func (s *SiteFormat) Detect(detect func() ListingFormat) ListingFormat {
+	s.once.Do(func() {
+		s.format = detect()
+		s.known = true
+	})
+	return s.format
+}
+
+// String returns the name of the format,
+// empty if no listing was read.
+func (s *SiteFormat) String() string {
+	if !s.known {
+		return ""
+	}
+	return s.format.String()
+}
+
+func constFormat(format ListingFormat) func() ListingFormat {
+	return func() ListingFormat { return format }
+}
+
+// Server header prefixes of servers with an autoindex
+var serverFormats = []struct{
+	prefix string
+	format ListingFormat
+}{
+	{"apache", FormatApache},
+	{"nginx", FormatNginx},
+	{"lighttpd", FormatLighttpd},
+	{"microsoft-iis", FormatIIS},
+	{"caddy", FormatCaddy},
+	{"simplehttp", FormatPython},
+}
+
+// FingerprintListing classifies a listing by markup signatures,
+// the Content-Type and the Server header. The Server header is
+// only used if the markup is inconclusive but the page looks
+// like an autoindex.
+func FingerprintListing(server, contentType string, body []byte) ListingFormat {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/json":
+		switch {
+		case bytes.Contains(body, []byte(`"mod_time"`)) ||
+			bytes.Contains(body, []byte(`"ModTime"`)):
+			return FormatCaddy
+		case bytes.Contains(body, []byte(`"mtime"`)):
+			return FormatNginx
+		}
+	case "application/xml", "text/xml":
+		if bytes.Contains(body, []byte("<list")) {
+			return FormatNginx
+		}
+	}
+
+	format := DetectListingFormat(body)
+	if format != FormatGeneric {
+		return format
+	}
+	if !bytes.Contains(body, []byte("Index of /")) {
+		return FormatGeneric
+	}
+
+	server = strings.ToLower(strings.TrimSpace(server))
+	for _, s := range serverFormats {
+		if strings.HasPrefix(server, s.prefix) {
+			return s.format
+		}
+	}
+	return FormatGeneric
+}
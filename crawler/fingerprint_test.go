@@ -0,0 +1,52 @@
+package crawler
+
+import "testing"
+
+func TestFingerprintListing(t *testing.T) {
+	tests := []struct{
+		name        string
+		server      string
+		contentType string
+		body        string
+		format      ListingFormat
+	}{
+		{"nginx", "nginx/1.14.0", "text/html", nginxListing, FormatNginx},
+		{"apache", "Apache/2.4.29 (Ubuntu)", "text/html", apache2Listing, FormatApache},
+		{"apache plain", "Apache", "text/html", apachePlainListing, FormatApachePlain},
+		{"lighttpd", "lighttpd/1.4.45", "text/html", lighttpdListing, FormatLighttpd},
+		{"iis", "Microsoft-IIS/10.0", "text/html", iisListing, FormatIIS},
+		{"caddy", "Caddy", "text/html", caddyListing, FormatCaddy},
+		{"python", "SimpleHTTP/0.6 Python/3.6.7", "text/html", pythonListing, FormatPython},
+		{"h5ai", "nginx", "text/html", h5aiListing, FormatH5ai},
+		{"directory lister", "Apache", "text/html", directoryListerListing, FormatDirectoryLister},
+		{"nginx json", "", "application/json", nginxJSONListing, FormatNginx},
+		{"nginx xml", "", "text/xml", nginxXMLListing, FormatNginx},
+		{"caddy json", "", "application/json", caddyJSONListing, FormatCaddy},
+		{"caddy v1 json", "", "application/json", caddyV1JSONListing, FormatCaddy},
+		{"server header", "nginx/1.14.0", "text/html",
+			`<html><title>Index of /pub/</title><a href="a.bin">a.bin</a></html>`, FormatNginx},
+		{"custom page", "nginx/1.14.0", "text/html",
+			`<html><title>My files</title><a href="a.bin">a.bin</a></html>`, FormatGeneric},
+	}
+
+	for _, test := range tests {
+		got := FingerprintListing(test.server, test.contentType, []byte(test.body))
+		if got != test.format {
+			t.Errorf("%s: expected %s, got %s", test.name, test.format, got)
+		}
+	}
+}
+
+func TestSiteFormat(t *testing.T) {
+	var s SiteFormat
+	if s.String() != "" {
+		t.Errorf("Expected no format, got %s", s.String())
+	}
+	s.Detect(constFormat(FormatNginx))
+	if got := s.Detect(constFormat(FormatApache)); got != FormatNginx {
+		t.Errorf("Format changed to %s", got)
+	}
+	if s.String() != "nginx" {
+		t.Errorf("Expected nginx, got %s", s.String())
+	}
+}
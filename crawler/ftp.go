@@ -0,0 +1,195 @@
+package crawler
+
+import (
+	"github.com/jlaffaye/ftp"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"net"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	ftpAnonymousUser = "anonymous"
+	ftpAnonymousPass = "anonymous@"
+)
+
+// FtpPool holds the control connections to one FTP server.
+// At most Options.MaxWorkers connections are open at once.
+type FtpPool struct {
+	c     *Crawler
+	od    *OD
+	addr  string
+	user  string
+	pass  string
+	idle  chan *ftp.ServerConn
+	slots chan struct{}
+}
+
+func NewFtpPool(o *OD) (p *FtpPool) {
+	p = new(FtpPool)
+	p.c = o.crawler
+	p.od = o
+	p.addr = o.BaseUri.Host
+	if _, _, err := net.SplitHostPort(p.addr); err != nil {
+		p.addr = net.JoinHostPort(p.addr, "21")
+	}
+
+	// fasturl drops userinfo, read it from the task
+	p.user = ftpAnonymousUser
+	p.pass = ftpAnonymousPass
+	if o.Task.Username != "" {
+		p.user = o.Task.Username
+		p.pass = o.Task.Password
+	} else if taskUrl, err := url.Parse(o.Task.Url); err == nil && taskUrl.User != nil {
+		p.user = taskUrl.User.Username()
+		p.pass, _ = taskUrl.User.Password()
+	}
+
+	p.idle = make(chan *ftp.ServerConn, p.c.opts.MaxWorkers)
+	p.slots = make(chan struct{}, p.c.opts.MaxWorkers)
+	return
+}
+
+func (p *FtpPool) acquire() (*ftp.ServerConn, error) {
+	p.slots <- struct{}{}
+
+	select {
+	case conn := <-p.idle:
+		return conn, nil
+	default:
+	}
+
+	conn, err := ftp.Dial(p.addr,
+		ftp.DialWithDialFunc(p.dial))
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+
+	if err := conn.Login(p.user, p.pass); err != nil {
+		conn.Quit()
+		<-p.slots
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (p *FtpPool) release(conn *ftp.ServerConn, err error) {
+	if _, ok := err.(*textproto.Error); err == nil || ok {
+		// Server replied, connection still usable
+		p.idle <- conn
+	} else {
+		conn.Quit()
+	}
+	<-p.slots
+}
+
+// Always returns nil (But implements io.Closer)
+func (p *FtpPool) Close() error {
+	for {
+		select {
+		case conn := <-p.idle:
+			conn.Quit()
+		default:
+			return nil
+		}
+	}
+}
+
+// GetDirFTP lists an FTP directory.
+// Unlike HTTP listings, FTP listings carry the size and
+// modification time, so files are returned directly.
+func GetDirFTP(p *FtpPool, j *Job, f *File) (links []fasturl.URL, files []File, err error) {
+	f.IsDir = true
+	f.Name = path.Base(j.Uri.Path)
+
+	conn, err := p.acquire()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dirPath := fasturl.PathUnescape(j.Uri.Path)
+	start := time.Now()
+	entries, err := conn.List(dirPath)
+	p.c.observeRequest("LIST", err, 200, start, 0)
+	p.release(conn, err)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filePath := strings.Trim(j.Uri.Path, "/")
+	for _, entry := range entries {
+		switch entry.Name {
+		case "", ".", "..":
+			continue
+		}
+		if strings.ContainsRune(entry.Name, '/') {
+			continue
+		}
+
+		name := url.PathEscape(entry.Name)
+
+		switch entry.Type {
+		case ftp.EntryTypeFolder:
+			link := j.Uri
+			link.Path = j.Uri.Path + name + "/"
+			links = append(links, link)
+
+		case ftp.EntryTypeFile:
+			file := File{
+				Name: name,
+				Size: int64(entry.Size),
+				Path: filePath,
+			}
+			if !entry.Time.IsZero() {
+				file.MTime = entry.Time.Unix()
+			}
+			files = append(files, file)
+
+		default:
+			// Don't follow symlinks,
+			// the target is unknown
+			continue
+		}
+	}
+
+	return
+}
+
+// Dials control and data connections
+func (p *FtpPool) dial(network, addr string) (net.Conn, error) {
+	proxy, err := p.od.proxyForAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	if proxy != nil {
+		conn, err = proxy.Dial(network, addr, p.c.opts.DialTimeout)
+	} else {
+		conn, err = net.DialTimeout(network, addr, p.c.opts.DialTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ftpConn{conn, p.c.opts.Timeout}, nil
+}
+
+// ftpConn applies the request timeout to every read and write
+type ftpConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c ftpConn) Read(b []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+func (c ftpConn) Write(b []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
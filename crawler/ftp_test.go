@@ -0,0 +1,267 @@
+package crawler
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+type ftpTestEntry struct {
+	name  string
+	dir   bool
+	size  int64
+}
+
+var ftpTestTree = map[string][]ftpTestEntry {
+	"/": {
+		{name: "pub", dir: true},
+		{name: "readme.txt", size: 42},
+	},
+	"/pub": {
+		{name: "a b.iso", size: 1 << 30},
+		{name: "linux", dir: true},
+	},
+	"/pub/linux": {
+		{name: "kernel.tar.gz", size: 1234},
+	},
+}
+
+var ftpTestFiles = []string {
+	"/readme.txt 42",
+	"/pub/a b.iso 1073741824",
+	"/pub/linux/kernel.tar.gz 1234",
+}
+
+var ftpTestTime = time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC)
+
+// ftpTestServer is a minimal in-process FTP server
+type ftpTestServer struct {
+	ln   net.Listener
+	user string
+	pass string
+	mlsd bool
+}
+
+func newFtpTestServer(t *testing.T, user, pass string, mlsd bool) *ftpTestServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ftpTestServer{ln: ln, user: user, pass: pass, mlsd: mlsd}
+	go s.serve()
+	return s
+}
+
+func (s *ftpTestServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *ftpTestServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format + "\r\n", args...)
+	}
+
+	var user string
+	var loggedIn bool
+	var data net.Listener
+	defer func() {
+		if data != nil {
+			data.Close()
+		}
+	}()
+
+	reply("220 test server ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			cmd, arg = line[:i], line[i+1:]
+		}
+
+		switch strings.ToUpper(cmd) {
+		case "USER":
+			user = arg
+			reply("331 password required")
+		case "PASS":
+			if user == s.user && arg == s.pass {
+				loggedIn = true
+				reply("230 logged in")
+			} else {
+				reply("530 login incorrect")
+			}
+		case "FEAT":
+			if s.mlsd {
+				reply("211-Features:\r\n MLST type*;size*;modify*;\r\n UTF8\r\n211 End")
+			} else {
+				reply("211-Features:\r\n UTF8\r\n211 End")
+			}
+		case "TYPE", "OPTS":
+			reply("200 ok")
+		case "EPSV", "PASV":
+			if data != nil {
+				data.Close()
+			}
+			data, err = net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				reply("425 can't open data connection")
+				continue
+			}
+			port := data.Addr().(*net.TCPAddr).Port
+			if strings.ToUpper(cmd) == "EPSV" {
+				reply("229 Entering Extended Passive Mode (|||%d|)", port)
+			} else {
+				reply("227 Entering Passive Mode (127,0,0,1,%d,%d)", port>>8, port&0xFF)
+			}
+		case "MLSD", "LIST":
+			if !loggedIn {
+				reply("530 not logged in")
+				continue
+			}
+			entries, ok := ftpTestTree[strings.TrimSuffix(arg, "/")]
+			if arg == "/" {
+				entries, ok = ftpTestTree["/"]
+			}
+			if !ok {
+				reply("550 no such directory")
+				continue
+			}
+			if data == nil {
+				reply("425 use PASV first")
+				continue
+			}
+			reply("150 here comes the listing")
+			dataConn, err := data.Accept()
+			if err != nil {
+				return
+			}
+			for _, e := range entries {
+				if strings.ToUpper(cmd) == "MLSD" {
+					typ := "file"
+					if e.dir {
+						typ = "dir"
+					}
+					fmt.Fprintf(dataConn, "type=%s;size=%d;modify=%s; %s\r\n",
+						typ, e.size, ftpTestTime.Format("20060102150405"), e.name)
+				} else {
+					mode := "-rw-r--r--"
+					if e.dir {
+						mode = "drwxr-xr-x"
+					}
+					fmt.Fprintf(dataConn, "%s 1 ftp ftp %d %s %s\r\n",
+						mode, e.size, ftpTestTime.Format("Jan _2  2006"), e.name)
+				}
+			}
+			dataConn.Close()
+			data.Close()
+			data = nil
+			reply("226 transfer complete")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (s *ftpTestServer) Close() {
+	s.ln.Close()
+}
+
+func newFtpTestCrawler(workers int) *Crawler {
+	return New(Options{
+		Workers:       workers,
+		JobBufferSize: -1,
+		DialTimeout:   time.Second,
+		Timeout:       time.Second,
+	})
+}
+
+func newFtpTestOD(t *testing.T, rawUrl string, workers int) *OD {
+	c := newFtpTestCrawler(workers)
+	var u fasturl.URL
+	if err := u.Parse(rawUrl); err != nil {
+		t.Fatal(err)
+	}
+	return c.NewOD(&Task{Url: rawUrl}, &u)
+}
+
+func checkFtpTestFiles(t *testing.T, result TaskResult, files []File) {
+	if result.StatusCode != "success" || result.ListingFormat != "ftp" {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	var got []string
+	for _, f := range files {
+		got = append(got, fmt.Sprintf("/%s %d",
+			strings.TrimPrefix(fasturl.PathUnescape(f.Path) + "/" +
+				fasturl.PathUnescape(f.Name), "/"), f.Size))
+		if f.MTime != ftpTestTime.Unix() {
+			t.Errorf("Wrong mtime for %s: %d", f.Name, f.MTime)
+		}
+	}
+	sort.Strings(got)
+	expected := append([]string(nil), ftpTestFiles...)
+	sort.Strings(expected)
+
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d files, got %d: %v", len(expected), len(got), got)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Errorf(`Expected "%s" got "%s"`, expected[i], got[i])
+		}
+	}
+}
+
+func TestCrawlFtpAnonymousMLSD(t *testing.T) {
+	s := newFtpTestServer(t, ftpAnonymousUser, ftpAnonymousPass, true)
+	defer s.Close()
+
+	result, files := crawlTestFiles(t, newFtpTestCrawler(2),
+		Task{Url: "ftp://" + s.ln.Addr().String() + "/"})
+	checkFtpTestFiles(t, result, files)
+}
+
+func TestCrawlFtpLoginLIST(t *testing.T) {
+	s := newFtpTestServer(t, "crawler", "s3cret", false)
+	defer s.Close()
+
+	result, files := crawlTestFiles(t, newFtpTestCrawler(2),
+		Task{Url: "ftp://crawler:s3cret@" + s.ln.Addr().String() + "/"})
+	checkFtpTestFiles(t, result, files)
+}
+
+func TestCrawlFtpLoginDenied(t *testing.T) {
+	s := newFtpTestServer(t, "crawler", "s3cret", true)
+	defer s.Close()
+
+	od := newFtpTestOD(t, "ftp://" + s.ln.Addr().String() + "/", 1)
+	pool := NewFtpPool(od)
+	defer pool.Close()
+
+	var f File
+	_, _, err := GetDirFTP(pool, &Job{Uri: od.BaseUri}, &f)
+	if err == nil {
+		t.Fatal("Expected login failure")
+	}
+	if shouldRetry(err) {
+		t.Errorf("Login failure should not be retried: %s", err)
+	}
+}
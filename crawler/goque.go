@@ -0,0 +1,75 @@
+package crawler
+
+import (
+	"github.com/beeker1121/goque"
+	"sync"
+)
+
+// goqueQueue stores jobs in a LevelDB directory
+type goqueQueue struct {
+	q *goque.Queue
+	// Jobs dequeued but not acked, at the head of q
+	read uint64
+	m    sync.Mutex
+}
+
+func openGoqueQueue(dir string) (*goqueQueue, error) {
+	q, err := goque.OpenQueue(dir)
+	if err != nil { return nil, err }
+	return &goqueQueue{q: q}, nil
+}
+
+func (g *goqueQueue) Enqueue(job *Job) error {
+	var gob JobGob
+	gob.ToGob(job)
+	_, err := g.q.EnqueueObject(gob)
+	return goqueError(err)
+}
+
+func (g *goqueQueue) Dequeue(job *Job) error {
+	g.m.Lock()
+	defer g.m.Unlock()
+	item, err := g.q.PeekByOffset(g.read)
+	if err != nil { return goqueError(err) }
+
+	var gob JobGob
+	if err := item.ToObject(&gob); err != nil {
+		return err
+	}
+	g.read++
+	gob.FromGob(job)
+	return nil
+}
+
+func (g *goqueQueue) Ack() error {
+	g.m.Lock()
+	defer g.m.Unlock()
+	for ; g.read > 0; g.read-- {
+		if _, err := g.q.Dequeue(); err != nil {
+			return goqueError(err)
+		}
+	}
+	return nil
+}
+
+func (g *goqueQueue) Len() int {
+	g.m.Lock()
+	defer g.m.Unlock()
+	return int(g.q.Length() - g.read)
+}
+
+// Always returns nil (But implements io.Closer)
+func (g *goqueQueue) Close() error {
+	g.q.Close()
+	return nil
+}
+
+func goqueError(err error) error {
+	switch err {
+	case goque.ErrEmpty, goque.ErrOutOfBounds:
+		return ErrQueueEmpty
+	case goque.ErrDBClosed:
+		return ErrQueueClosed
+	}
+	return err
+}
@@ -0,0 +1,145 @@
+package crawler
+
+import (
+	"bytes"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"github.com/valyala/fasthttp"
+	"strconv"
+	"sync"
+)
+
+// Some servers (PHP scripts, object storage mirrors) reject
+// HEAD or leave out the Content-Length. Files on these hosts
+// are requested with GET instead, closing the connection after
+// the headers so the body isn't downloaded.
+
+// How the info of a file is requested
+type fileMethod uint8
+const (
+	methodHead fileMethod = iota
+	// GET with "Range: bytes=0-0", size from Content-Range
+	methodRange
+	// GET, size from Content-Length
+	methodGet
+)
+
+// fileMethods remembers per host what worked.
+// Hosts not in the map get HEAD with a fallback.
+type fileMethods struct {
+	m     sync.Mutex
+	hosts map[string]fileMethod
+}
+
+func (m *fileMethods) get(host string) (method fileMethod, known bool) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	method, known = m.hosts[host]
+	return
+}
+
+func (m *fileMethods) set(host string, method fileMethod) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.hosts == nil {
+		m.hosts = make(map[string]fileMethod)
+	}
+	m.hosts[host] = method
+}
+
+// headFailed checks if a HEAD request should be retried with GET
+func headFailed(err error, sized bool) bool {
+	if httpErr, ok := err.(*HttpError); ok {
+		switch httpErr.Code {
+		case fasthttp.StatusForbidden,
+			fasthttp.StatusMethodNotAllowed,
+			fasthttp.StatusNotImplemented:
+			return true
+		}
+		return false
+	}
+	return err == nil && !sized
+}
+
+// statFile requests the size and date of a file.
+// Returns the method the server actually answered
+// (methodGet if it ignored the range) and whether
+// the size was found.
+func (o *OD) statFile(method fileMethod, u fasturl.URL, f *File) (final fasturl.URL, used fileMethod, sized bool, err error) {
+	c := o.crawler
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	if c.opts.UserAgent != "" {
+		req.Header.SetUserAgent(c.opts.UserAgent)
+	}
+	switch method {
+	case methodHead:
+		req.Header.SetMethod("HEAD")
+	case methodRange:
+		req.Header.Set("Range", "bytes=0-0")
+		req.SetConnectionClose()
+	case methodGet:
+		req.SetConnectionClose()
+	}
+
+	res := fasthttp.AcquireResponse()
+	res.SkipBody = true
+	defer fasthttp.ReleaseResponse(res)
+
+	final, err = o.doRequest(req, res, u, &o.BaseUri)
+	if err != nil {
+		return
+	}
+
+	used = method
+	status := res.StatusCode()
+	if method == methodRange && (status == fasthttp.StatusPartialContent ||
+		status == fasthttp.StatusRequestedRangeNotSatisfiable) {
+		size, ok := parseContentRange(res.Header.Peek("Content-Range"))
+		if !ok {
+			return final, used, false, &HttpError{Code: status}
+		}
+		f.Size = size
+		f.applyLastModified(string(res.Header.Peek("last-modified")))
+		if c.opts.FileMetadata && status == fasthttp.StatusPartialContent {
+			f.applyMetadata(&res.Header)
+			// Ranges obviously work
+			f.AcceptRanges = true
+		}
+		return final, used, true, nil
+	}
+	if method == methodRange {
+		// Range ignored
+		used = methodGet
+	}
+
+	err = checkResponse(res)
+	if err != nil {
+		return
+	}
+
+	contentLength := res.Header.Peek("content-length")
+	sized = len(contentLength) > 0
+	f.applyContentLength(string(contentLength))
+	f.applyLastModified(string(res.Header.Peek("last-modified")))
+	if c.opts.FileMetadata {
+		f.applyMetadata(&res.Header)
+	}
+	return final, used, sized, nil
+}
+
+// parseContentRange returns the total size of
+// "bytes 0-0/1234" or "bytes */1234".
+func parseContentRange(v []byte) (int64, bool) {
+	if !bytes.HasPrefix(v, []byte("bytes ")) {
+		return 0, false
+	}
+	slash := bytes.LastIndexByte(v, '/')
+	if slash < 0 {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(string(v[slash+1:]), 10, 64)
+	if err != nil || size < 0 {
+		return 0, false
+	}
+	return size, true
+}
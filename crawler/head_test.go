@@ -0,0 +1,180 @@
+package crawler
+
+import (
+	"bytes"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var headTestTime = time.Date(2018, 9, 1, 10, 0, 0, 0, time.UTC)
+
+// newHeadTestServer counts requests by method
+func newHeadTestServer(handler func(w http.ResponseWriter, r *http.Request)) (*httptest.Server, map[string]int) {
+	methods := make(map[string]int)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Method
+		if r.Header.Get("Range") != "" {
+			key += " range"
+		}
+		methods[key]++
+		handler(w, r)
+	}))
+	return s, methods
+}
+
+func getFileTest(t *testing.T, c *Crawler, rawUrl string) (File, error) {
+	t.Helper()
+	var u fasturl.URL
+	if err := u.Parse(rawUrl); err != nil {
+		t.Fatal(err)
+	}
+	var f File
+	_, err := c.NewOD(&Task{Url: rawUrl}, &u).GetFile(u, &f)
+	return f, err
+}
+
+func TestGetFileRangeFallback(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 1234)
+	s, methods := newHeadTestServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		http.ServeContent(w, r, "", headTestTime, bytes.NewReader(content))
+	})
+	defer s.Close()
+
+	c := New(Options{})
+	for _, name := range []string{"/a.bin", "/b.bin"} {
+		f, err := getFileTest(t, c, s.URL + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Size != 1234 || f.MTime != headTestTime.Unix() {
+			t.Errorf("Unexpected file %+v", f)
+		}
+	}
+	if methods["HEAD"] != 1 || methods["GET range"] != 2 {
+		t.Errorf("Expected HEAD once and GET with range twice, got %v", methods)
+	}
+}
+
+func TestGetFileEmptyRange(t *testing.T) {
+	s, _ := newHeadTestServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		http.ServeContent(w, r, "", headTestTime, bytes.NewReader(nil))
+	})
+	defer s.Close()
+
+	f, err := getFileTest(t, New(Options{}), s.URL + "/empty.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Size != 0 || f.MTime != headTestTime.Unix() {
+		t.Errorf("Unexpected file %+v", f)
+	}
+}
+
+func TestGetFileRangeIgnored(t *testing.T) {
+	s, methods := newHeadTestServer(func(w http.ResponseWriter, r *http.Request) {
+		// No Content-Length on HEAD, Range not supported
+		w.Header().Set("Last-Modified", headTestTime.Format(http.TimeFormat))
+		if r.Method == "HEAD" {
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(1 << 20))
+		w.Write(make([]byte, 1 << 20))
+	})
+	defer s.Close()
+
+	c := New(Options{})
+	for _, name := range []string{"/a.iso", "/b.iso"} {
+		f, err := getFileTest(t, c, s.URL + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Size != 1 << 20 {
+			t.Errorf("Unexpected file %+v", f)
+		}
+	}
+	if methods["HEAD"] != 1 || methods["GET range"] != 1 || methods["GET"] != 1 {
+		t.Errorf("Expected HEAD, GET with range and GET, got %v", methods)
+	}
+}
+
+func TestGetFileNoFallback(t *testing.T) {
+	s, methods := newHeadTestServer(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	defer s.Close()
+
+	_, err := getFileTest(t, New(Options{}), s.URL + "/missing.bin")
+	if httpErr, ok := err.(*HttpError); !ok || httpErr.Code != 404 {
+		t.Errorf("Expected 404, got %v", err)
+	}
+	if len(methods) != 1 || methods["HEAD"] != 1 {
+		t.Errorf("Expected a single HEAD, got %v", methods)
+	}
+}
+
+func TestGetFileMetadata(t *testing.T) {
+	s, _ := newHeadTestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx/1.14.0")
+		w.Header().Set("Content-Type", "application/x-iso9660-image")
+		w.Header().Set("ETag", `"5b8a6530-3e8"`)
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Disposition", `attachment; filename*=UTF-8''..%2Fdebian%20live.iso`)
+		w.Header().Set("Content-Length", "1000")
+	})
+	defer s.Close()
+
+	f, err := getFileTest(t, New(Options{}), s.URL + "/download.php")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.ContentType != "" || f.ETag != "" || f.Server != "" {
+		t.Errorf("Metadata without FileMetadata: %+v", f)
+	}
+
+	f, err = getFileTest(t, New(Options{FileMetadata: true}), s.URL + "/download.php")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.ContentType != "application/x-iso9660-image" || f.ETag != `"5b8a6530-3e8"` ||
+		!f.AcceptRanges || f.DispositionName != "debian live.iso" ||
+		f.Server != "nginx/1.14.0" || f.Size != 1000 {
+		t.Errorf("Unexpected file %+v", f)
+	}
+
+	// Files of listings need a request too
+	c := New(Options{FileMetadata: true, TrustListing: ConfidenceExact})
+	if c.opts.TrustListing != ConfidenceNone {
+		t.Error("Trusting listings with FileMetadata")
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := map[string]int64{
+		"bytes 0-0/1234":   1234,
+		"bytes */0":        0,
+		"bytes 0-0/*":      -1,
+		"items 0-0/12":     -1,
+		"":                 -1,
+	}
+	for v, expected := range tests {
+		size, ok := parseContentRange([]byte(v))
+		if !ok {
+			size = -1
+		}
+		if size != expected {
+			t.Errorf(`"%s": expected %d, got %d`, v, expected, size)
+		}
+	}
+}
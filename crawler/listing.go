@@ -0,0 +1,607 @@
+package crawler
+
+import (
+	"bytes"
+	"github.com/terorie/od-database-crawler/dates"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"golang.org/x/net/html"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// How much the metadata of a listing entry can be trusted
+type Confidence uint8
+const (
+	// Only the link is known
+	ConfidenceNone Confidence = iota
+	// Size is rounded (e.g. "1.2M") or date is missing
+	ConfidenceApprox
+	// Exact size in bytes and modification date
+	ConfidenceExact
+)
+
+// Directory listing server software
+type ListingFormat uint8
+const (
+	// Custom HTML page
+	FormatGeneric ListingFormat = iota
+	// Apache with FancyIndexing
+	FormatApache
+	FormatNginx
+	FormatLighttpd
+	FormatIIS
+	FormatCaddy
+	// Apache without FancyIndexing (<ul> list)
+	FormatApachePlain
+	FormatH5ai
+	// Python http.server / SimpleHTTPServer
+	FormatPython
+	FormatDirectoryLister
+	// Listings that are not HTML
+	FormatFTP
+	FormatWebDAV
+	FormatS3
+	FormatCount
+)
+
+var listingFormatNames = [FormatCount]string {
+	"generic",
+	"apache",
+	"nginx",
+	"lighttpd",
+	"iis",
+	"caddy",
+	"apache-plain",
+	"h5ai",
+	"python",
+	"directory-lister",
+	"ftp",
+	"webdav",
+	"s3",
+}
+
+func (f ListingFormat) String() string {
+	return listingFormatNames[f]
+}
+
+// A link found in a directory listing
+type DirEntry struct {
+	Link       fasturl.URL
+	Href       string
+	Name       string
+	IsDir      bool
+	Size       int64 // -1 if unknown
+	MTime      int64 // 0 if unknown
+	Confidence Confidence
+}
+
+// An anchor and the text surrounding it
+// on the same line or table row
+type listingRow struct {
+	href     string
+	text     string
+	head     string   // text before the anchor
+	tail     string   // text after the anchor
+	cells    []string // table cells after the anchor
+	sizeAttr string   // data-size attribute
+	timeAttr string   // <time datetime="…">
+}
+
+type listingParser func(row *listingRow, e *DirEntry)
+
+var listingParsers = [FormatCount]listingParser {
+	parseGenericRow,
+	parseApacheRow,
+	parseNginxRow,
+	parseLighttpdRow,
+	parseIISRow,
+	parseCaddyRow,
+	parseNameOnlyRow,
+	parseH5aiRow,
+	parseNameOnlyRow,
+	parseDirectoryListerRow,
+}
+
+func ParseDir(body []byte, baseUri *fasturl.URL) (entries []DirEntry, err error) {
+	return ParseDirFormat(body, baseUri, DetectListingFormat(body))
+}
+
+// ParseDirFormat parses an HTML listing with
+// the parser of an already known format.
+func ParseDirFormat(body []byte, baseUri *fasturl.URL, format ListingFormat) (entries []DirEntry, err error) {
+	parse := parseGenericRow
+	if format < FormatCount && listingParsers[format] != nil {
+		parse = listingParsers[format]
+	}
+
+	for _, row := range scanListing(body) {
+		href := row.href
+
+		if strings.LastIndexByte(href, '?') != -1 {
+			continue
+		}
+
+		switch href {
+		case "", " ", ".", "..", "/":
+			continue
+		}
+
+		link, ok := resolveEntry(baseUri, href)
+		if !ok {
+			continue
+		}
+
+		entry := DirEntry{
+			Link:  link,
+			Href:  href,
+			Name:  strings.TrimSpace(row.text),
+			IsDir: strings.HasSuffix(link.Path, "/"),
+			Size:  -1,
+		}
+		if !entry.IsDir {
+			parse(&row, &entry)
+			if entry.IsDir {
+				// Listing says it's a directory
+				entry.Link.Path += "/"
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// resolveEntry resolves a link of a listing and
+// checks that it points below the listed directory.
+func resolveEntry(baseUri *fasturl.URL, href string) (link fasturl.URL, ok bool) {
+	if strings.Contains(href, "../") {
+		return link, false
+	}
+
+	if err := baseUri.ParseRel(&link, href); err != nil {
+		return link, false
+	}
+
+	if link.Scheme != baseUri.Scheme ||
+		link.Host != baseUri.Host ||
+		link.Path == baseUri.Path ||
+		!strings.HasPrefix(link.Path, baseUri.Path) {
+		return link, false
+	}
+	return link, true
+}
+
+// splitEntries separates links that still have to be
+// visited from files with trusted metadata.
+func splitEntries(entries []DirEntry, trust Confidence) (links []fasturl.URL, files []File) {
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if e.IsDir || !trustListing(trust, e.Confidence) {
+			links = append(links, e.Link)
+			continue
+		}
+
+		// Ignore dupes
+		if seen[e.Link.Path] {
+			continue
+		}
+		seen[e.Link.Path] = true
+
+		files = append(files, e.File())
+	}
+	return
+}
+
+func trustListing(trust Confidence, c Confidence) bool {
+	return trust != ConfidenceNone && c >= trust
+}
+
+func (e *DirEntry) File() File {
+	p := path.Clean(e.Link.Path)
+	return File{
+		Name:  path.Base(p),
+		Size:  e.Size,
+		MTime: e.MTime,
+		Path:  strings.Trim(path.Dir(p), "/"),
+	}
+}
+
+// DetectListingFormat guesses the server software
+// from markup signatures of the listing page.
+func DetectListingFormat(body []byte) ListingFormat {
+	switch {
+	case bytes.Contains(body, []byte("/_h5ai/")) ||
+		bytes.Contains(body, []byte("powered by h5ai")):
+		return FormatH5ai
+	case bytes.Contains(body, []byte(`id="directory-listing"`)) ||
+		bytes.Contains(body, []byte("Directory Lister</a>")):
+		return FormatDirectoryLister
+	case bytes.Contains(body, []byte("<title>Directory listing for ")):
+		return FormatPython
+	case bytes.Contains(body, []byte(`data-size="`)) ||
+		bytes.Contains(body, []byte(`href="https://caddyserver.com"`)):
+		return FormatCaddy
+	case bytes.Contains(body, []byte(`<td class="n">`)) ||
+		bytes.Contains(body, []byte(`<div class="foot">lighttpd`)):
+		return FormatLighttpd
+	case bytes.Contains(body, []byte("[To Parent Directory]")) ||
+		bytes.Contains(body, []byte("&lt;dir&gt;")):
+		return FormatIIS
+	case bytes.Contains(body, []byte("<address>Apache")) ||
+		bytes.Contains(body, []byte(`alt="[DIR]"`)) ||
+		bytes.Contains(body, []byte(`alt="[PARENTDIR]"`)) ||
+		bytes.Contains(body, []byte(`?C=N;O=D`)):
+		return FormatApache
+	case bytes.Contains(body, []byte("<h1>Index of ")) &&
+		bytes.Contains(body, []byte("<ul><li><a href=")):
+		return FormatApachePlain
+	case bytes.Contains(body, []byte("<h1>Index of ")) &&
+		bytes.Contains(body, []byte("<pre>")):
+		return FormatNginx
+	default:
+		return FormatGeneric
+	}
+}
+
+// scanListing collects all anchors of a page
+// together with the text of their line or table row.
+func scanListing(body []byte) (rows []listingRow) {
+	doc := html.NewTokenizer(bytes.NewReader(body))
+
+	var cur *listingRow
+	var inAnchor, inRow bool
+	var line strings.Builder
+
+	closeRow := func() {
+		if cur != nil {
+			rows = append(rows, *cur)
+			cur = nil
+		}
+		inAnchor = false
+		line.Reset()
+	}
+
+	for {
+		tokenType := doc.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := doc.TagName()
+			switch string(name) {
+			case "a":
+				if cur != nil {
+					rows = append(rows, *cur)
+				}
+				cur = &listingRow{head: line.String()}
+				inAnchor = tokenType == html.StartTagToken
+				for hasAttr {
+					var ks, vs []byte
+					ks, vs, hasAttr = doc.TagAttr()
+					if bytes.Equal(ks, []byte("href")) {
+						// TODO Check escape
+						cur.href = string(vs)
+						break
+					}
+				}
+
+			case "tr":
+				closeRow()
+				inRow = true
+
+			case "br":
+				closeRow()
+
+			case "td", "th":
+				if cur == nil || inAnchor {
+					break
+				}
+				cur.cells = append(cur.cells, "")
+				for hasAttr {
+					var ks, vs []byte
+					ks, vs, hasAttr = doc.TagAttr()
+					if bytes.Equal(ks, []byte("data-size")) ||
+						bytes.Equal(ks, []byte("data-order")) {
+						cur.sizeAttr = string(vs)
+					}
+				}
+
+			case "time":
+				if cur == nil {
+					break
+				}
+				for hasAttr {
+					var ks, vs []byte
+					ks, vs, hasAttr = doc.TagAttr()
+					if bytes.Equal(ks, []byte("datetime")) {
+						cur.timeAttr = string(vs)
+					}
+				}
+			}
+
+		case html.EndTagToken:
+			name, _ := doc.TagName()
+			switch string(name) {
+			case "a":
+				inAnchor = false
+			case "tr":
+				closeRow()
+				inRow = false
+			}
+
+		case html.TextToken:
+			text := string(doc.Text())
+			if inAnchor {
+				cur.text += text
+				continue
+			}
+
+			if cur != nil && inRow {
+				// Table listing, one entry per row
+				if n := len(cur.cells); n > 0 {
+					cur.cells[n-1] += text
+				} else {
+					cur.tail += text
+				}
+				continue
+			}
+
+			// Pre-formatted listing, one entry per line
+			nl := strings.IndexByte(text, '\n')
+			rest := text
+			if nl >= 0 {
+				rest = text[:nl]
+			}
+			if cur != nil {
+				cur.tail += rest
+			} else {
+				line.WriteString(rest)
+			}
+
+			if nl >= 0 {
+				if cur != nil {
+					closeRow()
+				}
+				line.Reset()
+				line.WriteString(text[strings.LastIndexByte(text, '\n')+1:])
+			}
+		}
+	}
+
+	closeRow()
+	return
+}
+
+func parseApacheRow(row *listingRow, e *DirEntry) {
+	if len(row.cells) > 0 {
+		parseListingFields(strings.Join(row.cells, " "), apacheTimeLayouts, e)
+	} else {
+		// Plain FancyIndexing without HTMLTable
+		parseListingFields(row.tail, apacheTimeLayouts, e)
+	}
+}
+
+func parseNginxRow(row *listingRow, e *DirEntry) {
+	parseListingFields(row.tail, nginxTimeLayouts, e)
+}
+
+func parseLighttpdRow(row *listingRow, e *DirEntry) {
+	parseListingFields(strings.Join(row.cells, " "), lighttpdTimeLayouts, e)
+}
+
+func parseIISRow(row *listingRow, e *DirEntry) {
+	// IIS prints the metadata in front of the link
+	parseListingFields(row.head, iisTimeLayouts, e)
+}
+
+func parseCaddyRow(row *listingRow, e *DirEntry) {
+	if row.sizeAttr != "" {
+		size, err := strconv.ParseInt(row.sizeAttr, 10, 64)
+		if err == nil && size >= 0 {
+			e.Size = size
+		}
+	}
+	if row.timeAttr != "" {
+		if t, conf := dates.Parse(row.timeAttr); conf != dates.Invalid {
+			e.MTime = t.Unix()
+		}
+	}
+	if e.Size >= 0 && e.MTime != 0 {
+		e.Confidence = ConfidenceExact
+		return
+	}
+	parseGenericRow(row, e)
+}
+
+// h5ai without JavaScript, sizes are in units of 1000 bytes:
+// <td class="fb-d">2018-01-16 02:40</td><td class="fb-s">101681 KB</td>
+func parseH5aiRow(row *listingRow, e *DirEntry) {
+	for _, cell := range row.cells {
+		cell = strings.TrimSpace(cell)
+		if t, conf := dates.ParseLayouts(cell, h5aiTimeLayouts); conf != dates.Invalid {
+			e.MTime = t.Unix()
+			continue
+		}
+		fields := strings.Fields(cell)
+		if len(fields) == 2 && fields[1] == "KB" {
+			size, err := strconv.ParseInt(fields[0], 10, 64)
+			if err == nil && size >= 0 {
+				e.Size = size * 1000
+				e.Confidence = ConfidenceApprox
+			}
+		}
+	}
+}
+
+// Directory Lister puts the whole row into the anchor
+func parseDirectoryListerRow(row *listingRow, e *DirEntry) {
+	e.Name = fasturl.PathUnescape(path.Base(e.Link.Path))
+	fields := strings.Fields(row.text)
+	if n := strings.Count(e.Name, " ") + 1; n <= len(fields) {
+		fields = fields[n:]
+	}
+	parseListingFields(strings.Join(fields, " "), genericTimeLayouts, e)
+	if e.Size < 0 {
+		// Folders have no size and no trailing slash
+		e.IsDir = true
+	}
+}
+
+// Listings without metadata
+func parseNameOnlyRow(row *listingRow, e *DirEntry) {}
+
+func parseGenericRow(row *listingRow, e *DirEntry) {
+	text := row.tail
+	if len(row.cells) > 0 {
+		text = strings.Join(row.cells, " ")
+	}
+	parseListingFields(text, genericTimeLayouts, e)
+	if e.Confidence == ConfidenceNone {
+		parseListingFields(row.head, genericTimeLayouts, e)
+	}
+}
+
+var apacheTimeLayouts = []string {
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	"02-Jan-2006 15:04",
+}
+
+var nginxTimeLayouts = []string {
+	"02-Jan-2006 15:04",
+	"02-Jan-2006 15:04:05",
+}
+
+var lighttpdTimeLayouts = []string {
+	"2006-Jan-02 15:04:05",
+	"2006-Jan-02 15:04",
+}
+
+// Weekday and month names are normalized by
+// the dates package, "Monday, January" is "Mon, Jan"
+var iisTimeLayouts = []string {
+	"1/2/2006 3:04 PM",
+	"Mon, Jan 2, 2006 3:04 PM",
+	"2006-01-02 15:04",
+}
+
+var h5aiTimeLayouts = []string {
+	"2006-01-02 15:04",
+}
+
+var genericTimeLayouts = dates.ListingLayouts
+
+// parseListingFields extracts a date and a size
+// from the columns of a listing line.
+func parseListingFields(text string, layouts []string, e *DirEntry) {
+	fields := strings.Fields(text)
+
+	// Find date, it may span multiple fields.
+	// Longest match first, "2018-01-16" alone
+	// would drop the time.
+	var dateStart, dateEnd int
+	var dateConf dates.Confidence
+	found := false
+	for i := 0; i < len(fields) && !found; i++ {
+		for n := 6; n >= 1; n-- {
+			if i+n > len(fields) {
+				continue
+			}
+			candidate := strings.Join(fields[i:i+n], " ")
+			t, conf := dates.ParseLayouts(candidate, layouts)
+			if conf != dates.Invalid {
+				e.MTime = t.Unix()
+				dateStart, dateEnd = i, i+n
+				dateConf = conf
+				found = true
+				break
+			}
+		}
+	}
+	if found {
+		fields = append(fields[:dateStart:dateStart], fields[dateEnd:]...)
+	}
+
+	// Find size in remaining fields
+	exact := false
+	for i, field := range fields {
+		if field == "<dir>" || field == "<DIR>" {
+			e.IsDir = true
+			return
+		}
+		if i+1 < len(fields) && isSizeUnit(fields[i+1]) {
+			// Size with separate unit (e.g. "1.2 KiB")
+			field += fields[i+1]
+		}
+		size, isExact, ok := parseListingSize(field)
+		if ok {
+			e.Size = size
+			exact = isExact
+			break
+		}
+	}
+
+	switch {
+	case e.Size >= 0 && exact && dateConf >= dates.Minute:
+		e.Confidence = ConfidenceExact
+	case e.Size >= 0:
+		e.Confidence = ConfidenceApprox
+	}
+}
+
+// parseListingSize parses sizes like "123", "1.2K" or "12 MiB".
+// Sizes with a unit are rounded and not exact.
+func parseListingSize(s string) (size int64, exact bool, ok bool) {
+	numEnd := 0
+	for numEnd < len(s) {
+		c := s[numEnd]
+		if (c < '0' || c > '9') && c != '.' && c != ',' {
+			break
+		}
+		numEnd++
+	}
+	if numEnd == 0 {
+		return 0, false, false
+	}
+
+	num, unit := s[:numEnd], s[numEnd:]
+	multiplier, known := sizeUnits[unit]
+	if !known {
+		return 0, false, false
+	}
+
+	if multiplier == 1 && !strings.ContainsRune(num, '.') {
+		// Exact number of bytes, might have thousands separators
+		num = strings.Replace(num, ",", "", -1)
+		size, err := strconv.ParseInt(num, 10, 64)
+		if err != nil || size < 0 {
+			return 0, false, false
+		}
+		return size, true, true
+	}
+
+	num = strings.Replace(num, ",", ".", 1)
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 {
+		return 0, false, false
+	}
+	return int64(math.Round(f * float64(multiplier))), false, true
+}
+
+func isSizeUnit(s string) bool {
+	multiplier, ok := sizeUnits[s]
+	return ok && multiplier > 1
+}
+
+var sizeUnits = map[string]int64 {
+	"": 1, "B": 1, "b": 1, "bytes": 1,
+	"K": 1 << 10, "KB": 1 << 10, "KiB": 1 << 10, "k": 1000, "kB": 1000,
+	"M": 1 << 20, "MB": 1 << 20, "MiB": 1 << 20,
+	"G": 1 << 30, "GB": 1 << 30, "GiB": 1 << 30,
+	"T": 1 << 40, "TB": 1 << 40, "TiB": 1 << 40,
+	"P": 1 << 50, "PB": 1 << 50, "PiB": 1 << 50,
+}
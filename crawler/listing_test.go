@@ -0,0 +1,371 @@
+package crawler
+
+import (
+	"github.com/terorie/od-database-crawler/fasturl"
+	"testing"
+)
+
+// checkDirEntries compares the metadata of the
+// named entries, other entries are ignored.
+func checkDirEntries(t *testing.T, entries []DirEntry, expected []DirEntry) {
+	t.Helper()
+	byName := make(map[string]DirEntry)
+	for _, e := range entries {
+		byName[e.Name] = e
+	}
+	for _, exp := range expected {
+		got, ok := byName[exp.Name]
+		if !ok {
+			t.Errorf(`Missing entry "%s"`, exp.Name)
+			continue
+		}
+		if got.IsDir != exp.IsDir || got.Size != exp.Size ||
+			got.MTime != exp.MTime || got.Confidence != exp.Confidence {
+			t.Errorf(`Entry "%s": expected dir=%v size=%d mtime=%d conf=%d, ` +
+				`got dir=%v size=%d mtime=%d conf=%d`, exp.Name,
+				exp.IsDir, exp.Size, exp.MTime, exp.Confidence,
+				got.IsDir, got.Size, got.MTime, got.Confidence)
+		}
+	}
+}
+
+func parseDirTest(t *testing.T, listing string, rawUrl string, format ListingFormat) []DirEntry {
+	t.Helper()
+	var u fasturl.URL
+	err := u.Parse(rawUrl)
+	if err != nil {
+		t.Fatal("Failed to parse URL", err)
+	}
+
+	if got := DetectListingFormat([]byte(listing)); got != format {
+		t.Errorf("Expected format %s, got %s", format, got)
+	}
+
+	entries, err := ParseDir([]byte(listing), &u)
+	if err != nil {
+		t.Fatal("Failed to extract links", err)
+	}
+	return entries
+}
+
+func TestParseDirLighttpd(t *testing.T) {
+	entries := parseDirTest(t, lighttpdListing, "http://example.org/pub/", FormatLighttpd)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 links, got %d", len(entries))
+	}
+	checkDirEntries(t, entries, []DirEntry {
+		{Name: "docs/", IsDir: true, Size: -1},
+		{Name: "kernel.tar.xz", Size: 104123597,
+			MTime: 1516070412, Confidence: ConfidenceApprox},
+	})
+}
+
+func TestParseDirIIS(t *testing.T) {
+	entries := parseDirTest(t, iisListing, "http://example.org/files/", FormatIIS)
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 links, got %d", len(entries))
+	}
+	checkDirEntries(t, entries, []DirEntry {
+		{Name: "setup", IsDir: true, Size: -1, MTime: 1516060800},
+		{Name: "readme.txt", Size: 12345,
+			MTime: 1516060800, Confidence: ConfidenceExact},
+		{Name: "big.iso", Size: 4700000000,
+			MTime: 1516111200, Confidence: ConfidenceExact},
+	})
+	if entries[0].Link.String() != "http://example.org/files/setup/" {
+		t.Errorf("Expected <dir> entry to become a directory, got %s",
+			entries[0].Link.String())
+	}
+}
+
+func TestParseDirCaddy(t *testing.T) {
+	entries := parseDirTest(t, caddyListing, "http://example.org/", FormatCaddy)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 links, got %d", len(entries))
+	}
+	checkDirEntries(t, entries, []DirEntry {
+		{Name: "music", IsDir: true, Size: -1},
+		{Name: "song.flac", Size: 31457280,
+			MTime: 1546398245, Confidence: ConfidenceExact},
+	})
+}
+
+func TestDetectListingFormatFileName(t *testing.T) {
+	// A file named after a server is no signature
+	listing := "<h1>Index of /conf/</h1><hr><pre><a href=\"../\">../</a>\n" +
+		"<a href=\"Caddyfile\">Caddyfile</a>  16-Jan-2018 00:00  512\n</pre><hr>"
+	if got := DetectListingFormat([]byte(listing)); got != FormatNginx {
+		t.Errorf("Expected format nginx, got %s", got)
+	}
+}
+
+func TestParseDirApachePre(t *testing.T) {
+	entries := parseDirTest(t, apachePreListing, "http://example.org/pub/", FormatApache)
+	checkDirEntries(t, entries, []DirEntry {
+		{Name: "data/", IsDir: true, Size: -1},
+		{Name: "notes.txt", Size: 512,
+			MTime: 1516070400, Confidence: ConfidenceExact},
+		{Name: "image.img", Size: 2 << 30,
+			MTime: 1516070400, Confidence: ConfidenceApprox},
+	})
+}
+
+func TestParseDirApachePlain(t *testing.T) {
+	entries := parseDirTest(t, apachePlainListing, "http://example.org/pub/", FormatApachePlain)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 links, got %d", len(entries))
+	}
+	checkDirEntries(t, entries, []DirEntry {
+		{Name: "data/", IsDir: true, Size: -1},
+		{Name: "notes 2018.txt", Size: -1},
+	})
+}
+
+func TestParseDirPython(t *testing.T) {
+	entries := parseDirTest(t, pythonListing, "http://example.org/", FormatPython)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 links, got %d", len(entries))
+	}
+	checkDirEntries(t, entries, []DirEntry {
+		{Name: "music/", IsDir: true, Size: -1},
+		{Name: "song.flac", Size: -1},
+	})
+}
+
+func TestParseDirH5ai(t *testing.T) {
+	entries := parseDirTest(t, h5aiListing, "http://example.org/pub/", FormatH5ai)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 links, got %d", len(entries))
+	}
+	checkDirEntries(t, entries, []DirEntry {
+		{Name: "docs", IsDir: true, Size: -1},
+		{Name: "kernel.tar.xz", Size: 104123000,
+			MTime: 1516070400, Confidence: ConfidenceApprox},
+	})
+}
+
+func TestParseDirDirectoryLister(t *testing.T) {
+	entries := parseDirTest(t, directoryListerListing, "http://example.org/", FormatDirectoryLister)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 links, got %d", len(entries))
+	}
+	checkDirEntries(t, entries, []DirEntry {
+		{Name: "Some Docs", IsDir: true, Size: -1, MTime: 1516070412},
+		{Name: "kernel.tar.xz", Size: 104123597,
+			MTime: 1516070412, Confidence: ConfidenceApprox},
+	})
+	if entries[0].Link.Path != "/Some%20Docs/" {
+		t.Errorf("Unexpected link %s", entries[0].Link.Path)
+	}
+}
+
+func TestParseListingSize(t *testing.T) {
+	tests := []struct {
+		in    string
+		size  int64
+		exact bool
+		ok    bool
+	}{
+		{"0", 0, true, true},
+		{"12345", 12345, true, true},
+		{"12,345", 12345, true, true},
+		{"1.5K", 1536, false, true},
+		{"315K", 315 << 10, false, true},
+		{"1G", 1 << 30, false, true},
+		{"1.2KiB", 1229, false, true},
+		{"2kB", 2000, false, true},
+		{"-", 0, false, false},
+		{"", 0, false, false},
+		{"12Z", 0, false, false},
+		{"Jan", 0, false, false},
+	}
+	for _, test := range tests {
+		size, exact, ok := parseListingSize(test.in)
+		if size != test.size || exact != test.exact || ok != test.ok {
+			t.Errorf(`parseListingSize("%s") = %d, %v, %v; want %d, %v, %v`,
+				test.in, size, exact, ok, test.size, test.exact, test.ok)
+		}
+	}
+}
+
+func TestSplitEntriesTrust(t *testing.T) {
+	var u fasturl.URL
+	if err := u.Parse("http://example.org/pub/"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ParseDir([]byte(apachePreListing), &u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct{
+		trust Confidence
+		links int
+		files int
+	}{
+		{ConfidenceNone, 3, 0},
+		{ConfidenceExact, 2, 1},
+		{ConfidenceApprox, 1, 2},
+	} {
+		links, files := splitEntries(entries, test.trust)
+		if len(links) != test.links || len(files) != test.files {
+			t.Errorf("Trust %d: expected %d links and %d files, got %d and %d",
+				test.trust, test.links, test.files, len(links), len(files))
+		}
+	}
+
+	_, files := splitEntries(entries, ConfidenceExact)
+	if len(files) == 1 {
+		f := files[0]
+		if f.Name != "notes.txt" || f.Path != "pub" || f.Size != 512 {
+			t.Errorf("Unexpected file %+v", f)
+		}
+	}
+}
+
+const lighttpdListing =
+`<?xml version="1.0" encoding="iso-8859-1"?>
+<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.1//EN" "http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd">
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="en">
+<head><title>Index of /pub/</title></head>
+<body>
+<h2>Index of /pub/</h2>
+<div class="list">
+<table summary="Directory Listing" cellpadding="0" cellspacing="0">
+<thead><tr><th class="n">Name</th><th class="m">Last Modified</th><th class="s">Size</th><th class="t">Type</th></tr></thead>
+<tbody>
+<tr class="d"><td class="n"><a href="../">Parent Directory</a>/</td><td class="m">&nbsp;</td><td class="s">- &nbsp;</td><td class="t">Directory</td></tr>
+<tr class="d"><td class="n"><a href="docs/">docs/</a></td><td class="m">2018-Jan-16 02:40:12</td><td class="s">- &nbsp;</td><td class="t">Directory</td></tr>
+<tr><td class="n"><a href="kernel.tar.xz">kernel.tar.xz</a></td><td class="m">2018-Jan-16 02:40:12</td><td class="s">99.3M</td><td class="t">application/x-xz</td></tr>
+</tbody>
+</table>
+</div>
+<div class="foot">lighttpd/1.4.45</div>
+</body>
+</html>`
+
+const iisListing =
+`<html><head><title>example.org - /files/</title></head><body><H1>example.org - /files/</H1><hr>
+
+<pre><A HREF="/">[To Parent Directory]</A><br><br> 1/16/2018 12:00 AM        &lt;dir&gt; <A HREF="/files/setup">setup</A><br> 1/16/2018 12:00 AM        12345 <A HREF="/files/readme.txt">readme.txt</A><br> 1/16/2018 2:00 PM   4700000000 <A HREF="/files/big.iso">big.iso</A><br></pre><hr></body></html>`
+
+const caddyListing =
+`<!DOCTYPE html>
+<html>
+<head><title>/</title></head>
+<body>
+<main>
+<table aria-describedby="summary">
+<thead><tr><th><a href="?sort=name&order=desc">Name</a></th><th>Size</th><th>Modified</th></tr></thead>
+<tbody>
+<tr class="file">
+<td><a href="./music/"><span class="name">music</span></a></td>
+<td data-order="-1">&mdash;</td>
+<td class="hideable"><time datetime="2019-01-02T03:04:05Z">01/02/2019 03:04:05 AM +00:00</time></td>
+</tr>
+<tr class="file">
+<td><a href="./song.flac"><span class="name">song.flac</span></a></td>
+<td data-order="31457280">30 MiB</td>
+<td class="hideable"><time datetime="2019-01-02T03:04:05Z">01/02/2019 03:04:05 AM +00:00</time></td>
+</tr>
+</tbody>
+</table>
+</main>
+<footer>Served with <a rel="noopener noreferrer" href="https://caddyserver.com">Caddy</a></footer>
+</body>
+</html>`
+
+const apachePreListing =
+`<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 3.2 Final//EN">
+<html>
+ <head>
+  <title>Index of /pub</title>
+ </head>
+ <body>
+<h1>Index of /pub</h1>
+<pre><img src="/icons/blank.gif" alt="Icon "> <a href="?C=N;O=D">Name</a>                    <a href="?C=M;O=A">Last modified</a>      <a href="?C=S;O=A">Size</a>  <a href="?C=D;O=A">Description</a><hr><img src="/icons/back.gif" alt="[PARENTDIR]"> <a href="/">Parent Directory</a>                             -
+<img src="/icons/folder.gif" alt="[DIR]"> <a href="data/">data/</a>                   2018-01-16 02:40    -
+<img src="/icons/text.gif" alt="[TXT]"> <a href="notes.txt">notes.txt</a>               2018-01-16 02:40  512
+<img src="/icons/unknown.gif" alt="[   ]"> <a href="image.img">image.img</a>               2018-01-16 02:40  2.0G
+<hr></pre>
+<address>Apache/2.4.29 (Ubuntu) Server at example.org Port 80</address>
+</body></html>`
+
+const apachePlainListing =
+`<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 3.2 Final//EN">
+<html>
+ <head>
+  <title>Index of /pub</title>
+ </head>
+ <body>
+<h1>Index of /pub</h1>
+<ul><li><a href="/"> Parent Directory</a></li>
+<li><a href="data/"> data/</a></li>
+<li><a href="notes%202018.txt"> notes 2018.txt</a></li>
+</ul>
+</body></html>`
+
+const pythonListing =
+`<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd">
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<title>Directory listing for /</title>
+</head>
+<body>
+<h1>Directory listing for /</h1>
+<hr>
+<ul>
+<li><a href="music/">music/</a></li>
+<li><a href="song.flac">song.flac</a></li>
+</ul>
+<hr>
+</body>
+</html>`
+
+// h5ai without JavaScript
+const h5aiListing =
+`<!DOCTYPE html><html class="no-js" lang="en"><head><meta charset="utf-8">
+<title>index - powered by h5ai v0.29.0 (https://larsjung.de/h5ai/)</title>
+<link rel="stylesheet" href="/_h5ai/public/css/styles.css"></head>
+<body class="fallback"><div id="fallback-hints"><span class="noJsMsg">Works best with JavaScript enabled!</span></div>
+<div id="fallback"><table>
+<tr><th class="fb-i"></th><th class="fb-n"><span>Name</span></th><th class="fb-d"><span>Last modified</span></th><th class="fb-s"><span>Size</span></th></tr>
+<tr><td class="fb-i"><img src="/_h5ai/public/images/fallback/folder-parent.png" alt="folder-parent"/></td><td class="fb-n"><a href="..">Parent Directory</a></td><td class="fb-d"></td><td class="fb-s"></td></tr>
+<tr><td class="fb-i"><img src="/_h5ai/public/images/fallback/folder.png" alt="folder"/></td><td class="fb-n"><a href="/pub/docs/">docs</a></td><td class="fb-d">2018-01-16 02:40</td><td class="fb-s"></td></tr>
+<tr><td class="fb-i"><img src="/_h5ai/public/images/fallback/file.png" alt="file"/></td><td class="fb-n"><a href="/pub/kernel.tar.xz">kernel.tar.xz</a></td><td class="fb-d">2018-01-16 02:40</td><td class="fb-s">104123&nbsp;KB</td></tr>
+</table></div></body></html>`
+
+// Directory Lister 3
+const directoryListerListing =
+`<!DOCTYPE html>
+<html lang="en">
+<head><title>Directory Lister</title></head>
+<body>
+<div id="directory-listing" class="container mx-auto">
+<ul>
+<li>
+<a href="/Some%20Docs" class="flex flex-col items-center rounded-lg font-mono group">
+<div class="flex justify-between items-center p-4 w-full">
+<div class="pr-2"><i class="fas fa-folder fa-fw fa-lg"></i></div>
+<div class="flex-1 truncate">Some Docs</div>
+<div class="hidden whitespace-nowrap text-right mx-2 w-1/6 sm:block">&mdash;</div>
+<div class="hidden whitespace-nowrap text-right truncate ml-2 w-1/4 sm:block">2018-01-16 02:40:12</div>
+</div>
+</a>
+</li>
+<li>
+<a href="/kernel.tar.xz" class="flex flex-col items-center rounded-lg font-mono group">
+<div class="flex justify-between items-center p-4 w-full">
+<div class="pr-2"><i class="fas fa-file-archive fa-fw fa-lg"></i></div>
+<div class="flex-1 truncate">kernel.tar.xz</div>
+<div class="hidden whitespace-nowrap text-right mx-2 w-1/6 sm:block">99.30MB</div>
+<div class="hidden whitespace-nowrap text-right truncate ml-2 w-1/4 sm:block">2018-01-16 02:40:12</div>
+</div>
+</a>
+</li>
+</ul>
+</div>
+<footer>Powered by <a href="https://www.directorylister.com">Directory Lister</a></footer>
+</body>
+</html>`
@@ -0,0 +1,122 @@
+package crawler
+
+import (
+	"context"
+	"github.com/terorie/od-database-crawler/ds/redblackhash"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"sync"
+	"time"
+)
+
+type Task struct {
+	WebsiteId uint64 `json:"website_id"`
+	Url       string `json:"url"`
+	// Proxy URL for this task, see ParseProxy
+	Proxy     string `json:"proxy,omitempty"`
+	// Credentials for HTTP Basic or Digest auth and FTP,
+	// taken from the URL if empty
+	Username  string `json:"username,omitempty"`
+	Password  string `json:"password,omitempty"`
+	// Extra request headers, e.g. Cookie or Referer
+	Headers   map[string]string `json:"headers,omitempty"`
+}
+
+type TaskResult struct {
+	StatusCode    string    `json:"status_code"`
+	FileCount     uint64    `json:"file_count"`
+	ErrorCount    uint64    `json:"-"`
+	StartTime     time.Time `json:"-"`
+	StartTimeUnix int64     `json:"start_time"`
+	EndTimeUnix   int64     `json:"end_time"`
+	WebsiteId     uint64    `json:"website_id"`
+	// Detected server software, e.g. "nginx" or "generic"
+	ListingFormat string    `json:"listing_format,omitempty"`
+	// Links not followed because of robots.txt
+	SkippedByPolicy uint64  `json:"skipped_by_policy"`
+	// Pages below Options.MinListingScore
+	SkippedNotListing uint64 `json:"skipped_not_listing"`
+}
+
+type Job struct {
+	Uri       fasturl.URL
+	UriStr    string
+	Fails     int
+	LastError error
+	// Checkpoint generation when queued to disk
+	Gen       int
+	// Attempts answered with a rate limit and the backoff
+	// after them, see freeRateLimit. Not kept on disk.
+	RateLimits    int
+	RateLimitWait time.Duration
+}
+
+type OD struct {
+	Task    Task
+	Result  TaskResult
+	Wait    sync.WaitGroup
+	BaseUri fasturl.URL
+	WCtx    WorkerContext
+	Scanned redblackhash.Tree
+	// Listing format of the site
+	Format  SiteFormat
+
+	crawler  *Crawler
+	// State to resume from
+	resume   *State
+	started  bool
+	finished bool
+	// Canceled when the crawl is over
+	ctx      context.Context
+	cancel   context.CancelFunc
+	// Server supports WebDAV listings
+	dav      bool
+	// Bucket if the site is on S3
+	s3       *S3Bucket
+	// Both are probed after the first listing, see probeListing
+	probed   bool
+	probeM   sync.Mutex
+	// Directories listed
+	dirCount uint64
+	// Rules of robots.txt, fetched once it succeeds
+	robots     *Robots
+	robotsDone bool
+	robotsM    sync.Mutex
+	// Status code if stopped at a limit
+	stopStatus string
+	stopM      sync.Mutex
+	// Client, proxy and session of the task
+	route      route
+}
+
+type File struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	MTime int64  `json:"mtime"`
+	Path  string `json:"path"`
+	IsDir bool   `json:"-"`
+
+	// Response headers, only set with Options.FileMetadata
+	// and if the file was requested (not from a listing)
+	ContentType     string `json:"content_type,omitempty"`
+	ETag            string `json:"etag,omitempty"`
+	AcceptRanges    bool   `json:"accept_ranges,omitempty"`
+	// File name from Content-Disposition
+	DispositionName string `json:"disposition_name,omitempty"`
+	Server          string `json:"server,omitempty"`
+}
+
+func (o *OD) LoadOrStoreKey(k *redblackhash.Key) (exists bool) {
+	o.Scanned.Lock()
+	defer o.Scanned.Unlock()
+
+	exists = o.Scanned.Get(k)
+	if exists { return true }
+
+	o.Scanned.Put(k)
+	return false
+}
+
+type errorString string
+func (e errorString) Error() string {
+	return string(e)
+}
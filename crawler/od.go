@@ -0,0 +1,401 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"github.com/terorie/od-database-crawler/ds/redblackhash"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Values of OD.StopMode
+const (
+	StopNone   = iota
+	// Keep the results found so far
+	StopFinish
+	// Discard results
+	StopCancel
+)
+
+// State is the progress of an unfinished crawl.
+// Jobs that overflowed to disk are kept in the queue directory
+// until the state is saved.
+type State struct {
+	// False if the crawl hasn't been started yet
+	Started    bool
+	FileCount  uint64
+	ErrorCount uint64
+	DirCount   uint64
+	SkippedByPolicy uint64
+	SkippedNotListing uint64
+	StartTime  time.Time
+	// Jobs buffered in memory
+	Jobs       []JobGob
+	// Generation of the disk queue, later jobs on disk
+	// are found again by crawling Jobs
+	Generation int
+	// Hashes of visited directories
+	Scanned    []redblackhash.Key
+}
+
+// NewOD prepares the crawl of a site.
+// u is the parsed base URL of the task.
+func (c *Crawler) NewOD(t *Task, u *fasturl.URL) *OD {
+	now := time.Now()
+	o := &OD {
+		Task: *t,
+		BaseUri: *u,
+		Result: TaskResult {
+			WebsiteId: t.WebsiteId,
+			StartTime: now,
+			StartTimeUnix: now.Unix(),
+		},
+		crawler: c,
+	}
+	o.ctx, o.cancel = context.WithCancel(context.Background())
+	o.WCtx.OD = o
+	o.initRoute()
+	return o
+}
+
+// Restore loads the state of a previous crawl.
+// Must be called before Start.
+func (o *OD) Restore(s *State) {
+	o.resume = s
+	o.Result.FileCount = s.FileCount
+	o.Result.ErrorCount = s.ErrorCount
+	o.dirCount = s.DirCount
+	o.Result.SkippedByPolicy = s.SkippedByPolicy
+	o.Result.SkippedNotListing = s.SkippedNotListing
+	o.Result.StartTime = s.StartTime
+	o.Result.StartTimeUnix = s.StartTime.Unix()
+
+	o.Scanned.Lock()
+	for i := range s.Scanned {
+		o.Scanned.Put(&s.Scanned[i])
+	}
+	o.Scanned.Unlock()
+}
+
+// Start spawns the workers of the crawl. Files are sent to results.
+// Wait on o.Wait for the crawl to finish or the task to be
+// suspended, then call MarkFinished and Close.
+// Canceling ctx drops the remaining jobs.
+func (o *OD) Start(ctx context.Context, results chan<- File) error {
+	c := o.crawler
+
+	// Hold workers and snapshots until set up
+	o.WCtx.gate.Lock()
+	defer o.WCtx.gate.Unlock()
+
+	if o.finished {
+		// Suspended before it started
+		return nil
+	}
+
+	// Get queue path
+	var queuePath string
+	if c.opts.JobBufferSize >= 0 {
+		queuePath = path.Join(c.opts.QueueDir, fmt.Sprintf("%d", o.Task.WebsiteId))
+		// Delete existing queue
+		if o.resume == nil {
+			if err := os.RemoveAll(queuePath);
+				err != nil { return err }
+		}
+	}
+
+	// Start new queue
+	var err error
+	o.WCtx.Queue, err = OpenQueue(queuePath, c.opts.QueueBackend, c.opts.JobBufferSize, &c.stats.Queued)
+	if err != nil { return err }
+	resumed := o.resume != nil && o.resume.Started
+	if resumed {
+		err = o.WCtx.Queue.Restore(o.resume.Jobs, o.resume.Generation)
+		if err != nil { return err }
+	}
+
+	// Connect to FTP server on demand
+	if o.BaseUri.Scheme == fasturl.SchemeFTP {
+		o.WCtx.FTP = NewFtpPool(o)
+	}
+
+	// Fail early if the proxy doesn't work
+	if err := o.checkRoute(); err != nil {
+		c.log.WithError(err).
+			WithField("url", o.Task.Url).
+			Error("Proxy unusable")
+		o.stopAt(StatusProxyError)
+	}
+
+	// Spawn workers, some idle until the site keeps up
+	o.WCtx.conc.init(c.opts.Workers, c.opts.MaxWorkers)
+	o.WCtx.workers.Add(c.opts.MaxWorkers)
+	for i := 0; i < c.opts.MaxWorkers; i++ {
+		go o.WCtx.Worker(results)
+	}
+
+	// Enqueue initial job
+	if resumed {
+		pending := o.WCtx.Queue.Len()
+		o.Wait.Add(pending)
+		atomic.AddInt64(&o.WCtx.pending, int64(pending))
+	} else if o.StopMode() == StopNone {
+		o.WCtx.queueJob(Job{
+			Uri:    o.BaseUri,
+			UriStr: o.BaseUri.String(),
+			Fails:  0,
+		})
+	}
+
+	go func() {
+		// Time limit
+		var deadline <-chan time.Time
+		if c.opts.MaxDuration > 0 {
+			timer := time.NewTimer(c.opts.MaxDuration - time.Since(o.Result.StartTime))
+			defer timer.Stop()
+			deadline = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			o.Stop(StopCancel)
+		case <-deadline:
+			o.stopAt(StatusTimeLimit)
+		case <-o.ctx.Done():
+		}
+	}()
+
+	o.started = true
+	return nil
+}
+
+// MarkFinished is called once o.Wait is done. It returns false
+// if the crawl was suspended instead, the task must not be
+// closed then. A finished crawl can't be suspended anymore.
+func (o *OD) MarkFinished() bool {
+	o.WCtx.gate.Lock()
+	defer o.WCtx.gate.Unlock()
+	if o.finished {
+		return false
+	}
+	o.finished = true
+	return true
+}
+
+// Close stops the workers, releases the queue and connections
+// after the crawl is done and sets the end time and status code.
+func (o *OD) Close() error {
+	o.WCtx.gate.Lock()
+	o.finished = true
+	o.cancel()
+	o.WCtx.gate.Unlock()
+	o.WCtx.workers.Wait()
+
+	if err := o.WCtx.Queue.Close(); err != nil {
+		return err
+	}
+	if o.WCtx.FTP != nil {
+		o.WCtx.FTP.Close()
+	}
+
+	// Set status code
+	now := time.Now()
+	o.Result.EndTimeUnix = now.Unix()
+	o.Result.ListingFormat = o.Format.String()
+	fileCount := atomic.LoadUint64(&o.Result.FileCount)
+	if fileCount == 0 {
+		errorCount := atomic.LoadUint64(&o.Result.ErrorCount)
+		if errorCount == 0 {
+			o.Result.StatusCode = "empty"
+		} else {
+			o.Result.StatusCode = "directory listing failed"
+		}
+	} else {
+		o.Result.StatusCode = "success"
+	}
+	if status := o.stopStatusCode(); status != "" {
+		o.Result.StatusCode = status
+	}
+	return nil
+}
+
+// Snapshot stops the workers, passes the state of the crawl
+// to fn and lets the workers continue. No files are sent
+// while fn runs. If fn returns nil, the jobs taken from
+// the disk queue so far are deleted.
+func (o *OD) Snapshot(fn func(s *State) error) error {
+	o.WCtx.gate.Lock()
+	defer o.WCtx.gate.Unlock()
+	err := fn(o.state())
+	if err == nil && o.started {
+		err = o.WCtx.Queue.Ack()
+	}
+	return err
+}
+
+// Suspend stops the workers for good and passes the state of
+// the crawl to fn. The disk queue is kept to resume later and
+// o.Wait is released. Returns false if the crawl has already
+// finished.
+func (o *OD) Suspend(fn func(s *State) error) (bool, error) {
+	o.WCtx.gate.Lock()
+	if o.finished {
+		o.WCtx.gate.Unlock()
+		return false, nil
+	}
+	o.finished = true
+	o.cancel()
+
+	err := fn(o.state())
+	if o.started {
+		if err == nil {
+			err = o.WCtx.Queue.Ack()
+		}
+		// Workers find the queue closed and quit
+		o.WCtx.Queue.Suspend()
+	}
+	o.WCtx.gate.Unlock()
+	o.WCtx.workers.Wait()
+
+	// Nothing works on the remaining jobs anymore
+	o.Wait.Add(-int(atomic.SwapInt64(&o.WCtx.pending, 0)))
+	return true, err
+}
+
+// Must be called with all workers stopped
+func (o *OD) state() *State {
+	s := &State{
+		Started:    o.started,
+		FileCount:  atomic.LoadUint64(&o.Result.FileCount),
+		ErrorCount: atomic.LoadUint64(&o.Result.ErrorCount),
+		DirCount:   atomic.LoadUint64(&o.dirCount),
+		SkippedByPolicy: atomic.LoadUint64(&o.Result.SkippedByPolicy),
+		SkippedNotListing: atomic.LoadUint64(&o.Result.SkippedNotListing),
+		StartTime:  o.Result.StartTime,
+	}
+
+	if !o.started {
+		// Start over next time
+		return s
+	}
+
+	s.Jobs, s.Generation = o.WCtx.Queue.Snapshot()
+
+	o.Scanned.Lock()
+	s.Scanned = o.Scanned.Keys()
+	o.Scanned.Unlock()
+
+	return s
+}
+
+// Pause lets the workers finish their current
+// request and blocks them until Resume is called.
+func (o *OD) Pause() bool {
+	w := &o.WCtx
+	w.pauseM.Lock()
+	defer w.pauseM.Unlock()
+	if w.resumeC != nil {
+		return false
+	}
+	w.resumeC = make(chan struct{})
+	return true
+}
+
+func (o *OD) Resume() bool {
+	w := &o.WCtx
+	w.pauseM.Lock()
+	defer w.pauseM.Unlock()
+	if w.resumeC == nil {
+		return false
+	}
+	close(w.resumeC)
+	w.resumeC = nil
+	return true
+}
+
+func (o *OD) Paused() bool {
+	w := &o.WCtx
+	w.pauseM.Lock()
+	defer w.pauseM.Unlock()
+	return w.resumeC != nil
+}
+
+// Stop drops all remaining jobs of the task.
+// mode is either StopFinish or StopCancel.
+func (o *OD) Stop(mode int32) bool {
+	if !atomic.CompareAndSwapInt32(&o.WCtx.stopped, StopNone, mode) {
+		return false
+	}
+	o.Resume()
+	return true
+}
+
+// StopMode returns how the crawl was stopped
+func (o *OD) StopMode() int32 {
+	return atomic.LoadInt32(&o.WCtx.stopped)
+}
+
+// Workers returns the number of workers currently
+// allowed to crawl, see Options.MaxWorkers
+func (o *OD) Workers() int {
+	return o.WCtx.conc.Limit()
+}
+
+// Pending returns the number of unfinished jobs
+func (o *OD) Pending() int64 {
+	return atomic.LoadInt64(&o.WCtx.pending)
+}
+
+// Rate returns the jobs per second
+func (o *OD) Rate() float64 {
+	return o.WCtx.rate.Rate()
+}
+
+// rateMeter counts events over the last ten seconds
+type rateMeter struct {
+	m       sync.Mutex
+	buckets [10]uint64
+	// Unix time of the newest bucket
+	second  int64
+}
+
+func (r *rateMeter) Add() {
+	r.m.Lock()
+	defer r.m.Unlock()
+	now := time.Now().Unix()
+	r.advance(now)
+	r.buckets[now % int64(len(r.buckets))]++
+}
+
+// Rate returns the events per second,
+// excluding the current second.
+func (r *rateMeter) Rate() float64 {
+	r.m.Lock()
+	defer r.m.Unlock()
+	now := time.Now().Unix()
+	r.advance(now)
+
+	var sum uint64
+	for i := range r.buckets {
+		if int64(i) != now % int64(len(r.buckets)) {
+			sum += r.buckets[i]
+		}
+	}
+	return float64(sum) / float64(len(r.buckets) - 1)
+}
+
+func (r *rateMeter) advance(now int64) {
+	if now - r.second >= int64(len(r.buckets)) {
+		r.buckets = [len(r.buckets)]uint64{}
+		r.second = now
+		return
+	}
+	for r.second < now {
+		r.second++
+		r.buckets[r.second % int64(len(r.buckets))] = 0
+	}
+}
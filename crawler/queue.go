@@ -0,0 +1,337 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrQueueEmpty  = errors.New("queue empty")
+var ErrQueueClosed = errors.New("queue closed")
+
+// JobQueue stores jobs in FIFO order.
+// Implementations are safe for concurrent use.
+type JobQueue interface {
+	Enqueue(job *Job) error
+	// Returns ErrQueueEmpty if there are no jobs.
+	// Persistent queues keep the job until Ack.
+	Dequeue(job *Job) error
+	// Ack deletes the jobs dequeued so far
+	Ack() error
+	// Number of jobs not dequeued yet
+	Len() int
+	// Close releases the queue. Persistent queues
+	// keep their jobs until the directory is deleted.
+	Close() error
+}
+
+// QueueBackend selects where jobs beyond
+// Options.JobBufferSize are kept
+type QueueBackend int
+
+const (
+	// LevelDB queue (goque), a directory per task
+	QueueGoque QueueBackend = iota
+	// Embedded bbolt store, a single file per task
+	QueueBolt
+	// No disk, all jobs are kept in memory
+	QueueMemory
+)
+
+// OpenJobQueue opens the queue of a backend in dir.
+// Jobs left over in dir are kept.
+func OpenJobQueue(backend QueueBackend, dir string) (JobQueue, error) {
+	switch backend {
+	case QueueGoque:
+		return openGoqueQueue(dir)
+	case QueueBolt:
+		return openBoltQueue(dir)
+	case QueueMemory:
+		return newRingQueue(), nil
+	default:
+		return nil, fmt.Errorf("unknown queue backend %d", backend)
+	}
+}
+
+// BufferedQueue keeps jobs in memory up to a limit and
+// offloads the rest to a JobQueue. Dequeue blocks on an
+// empty queue.
+type BufferedQueue struct {
+	dataDir string
+	// Jobs beyond bufSize, nil if memory only
+	disk    JobQueue
+	buf     *ringQueue
+	bufSize int
+	m       sync.Mutex
+	// Shared count of queued jobs
+	queued  *int64
+	// Closed and replaced when jobs are added
+	// or the queue is closed
+	wake    chan struct{}
+	closed  bool
+	// Generation of jobs queued to disk,
+	// increased by every snapshot
+	gen     int
+}
+
+// OpenQueue keeps up to bufSize jobs in memory and the
+// rest in dataDir, stored by backend. If bufSize is negative,
+// all jobs are kept in memory. queued is updated with the
+// queue length.
+func OpenQueue(dataDir string, backend QueueBackend, bufSize int, queued *int64) (bq *BufferedQueue, err error) {
+	bq = new(BufferedQueue)
+	bq.buf = newRingQueue()
+	bq.bufSize = bufSize
+	bq.queued = queued
+	bq.wake = make(chan struct{})
+	if bufSize < 0 || backend == QueueMemory {
+		bq.bufSize = -1
+		return
+	}
+	bq.dataDir = dataDir
+	bq.disk, err = OpenJobQueue(backend, dataDir)
+	if err != nil { return nil, err }
+	// Jobs left over from a suspended crawl
+	atomic.AddInt64(bq.queued, int64(bq.disk.Len()))
+	return
+}
+
+func (q *BufferedQueue) Enqueue(job *Job) error {
+	if q.isClosed() {
+		return ErrQueueClosed
+	}
+	if !q.buf.push(job, q.bufSize) {
+		if q.disk == nil {
+			return ErrQueueClosed
+		}
+		job.Gen = q.gen
+		if err := q.disk.Enqueue(job); err != nil {
+			return err
+		}
+	}
+	atomic.AddInt64(q.queued, 1)
+	q.notify()
+	return nil
+}
+
+// Dequeue blocks until a job is available.
+// Returns ErrQueueClosed once the queue is closed
+// or the error of ctx once it is done.
+func (q *BufferedQueue) Dequeue(ctx context.Context) (job Job, err error) {
+	for {
+		if err = q.Wait(ctx); err != nil {
+			return
+		}
+		job, err = q.TryDequeue()
+		if err != ErrQueueEmpty {
+			return
+		}
+	}
+}
+
+// Wait blocks until the queue has jobs or is closed,
+// or ctx is done. Another caller might take the job.
+func (q *BufferedQueue) Wait(ctx context.Context) error {
+	for {
+		q.m.Lock()
+		wake, closed := q.wake, q.closed
+		q.m.Unlock()
+		if closed {
+			return ErrQueueClosed
+		}
+		if q.Len() > 0 {
+			return nil
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Len returns the number of queued jobs
+func (q *BufferedQueue) Len() int {
+	return q.buf.Len() + q.DiskLen()
+}
+
+// TryDequeue returns the next job without waiting,
+// ErrQueueEmpty if there is none.
+func (q *BufferedQueue) TryDequeue() (job Job, err error) {
+	if q.isClosed() {
+		return job, ErrQueueClosed
+	}
+	err = q.buf.Dequeue(&job)
+	if err == ErrQueueEmpty && q.disk != nil {
+		err = q.disk.Dequeue(&job)
+	}
+	if err != nil { return }
+
+	atomic.AddInt64(q.queued, -1)
+	return
+}
+
+// notify wakes up the callers of Wait
+func (q *BufferedQueue) notify() {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if !q.closed {
+		close(q.wake)
+		q.wake = make(chan struct{})
+	}
+}
+
+func (q *BufferedQueue) isClosed() bool {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.closed
+}
+
+// setClosed marks the queue closed and wakes up
+// waiting callers. Returns false if already closed.
+func (q *BufferedQueue) setClosed() bool {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if q.closed {
+		return false
+	}
+	q.closed = true
+	close(q.wake)
+	// Jobs left are dropped or persisted
+	atomic.AddInt64(q.queued, -int64(q.buf.Len() + q.DiskLen()))
+	q.buf.Close()
+	return true
+}
+
+// Close drops the remaining jobs and deletes the
+// disk queue. Always returns nil (But implements io.Closer)
+func (q *BufferedQueue) Close() error {
+	if !q.setClosed() || q.disk == nil {
+		return nil
+	}
+
+	// Close ignoring errors
+	q.disk.Close()
+
+	// Delete files
+	if err := os.RemoveAll(q.dataDir);
+		err != nil { panic(err) }
+
+	return nil
+}
+
+// Returns the number of jobs on disk
+func (q *BufferedQueue) DiskLen() int {
+	if q.disk == nil {
+		return 0
+	}
+	return q.disk.Len()
+}
+
+// Snapshot returns a copy of the jobs buffered in memory and
+// the generation of the snapshot. The jobs on disk are persisted
+// by the backend, jobs queued to disk later get a newer generation.
+// Jobs dequeued from disk stay there until Ack is called once the
+// snapshot is saved. Must not be called concurrently with Enqueue.
+func (q *BufferedQueue) Snapshot() (jobs []JobGob, gen int) {
+	jobs = make([]JobGob, 0, q.buf.Len())
+	q.buf.each(func(job *Job) {
+		var gob JobGob
+		gob.ToGob(job)
+		jobs = append(jobs, gob)
+	})
+	gen = q.gen
+	q.gen++
+	return
+}
+
+// Ack deletes the jobs dequeued from disk since the last Ack.
+// Jobs dequeued later are read again after a crash.
+func (q *BufferedQueue) Ack() error {
+	if q.disk == nil {
+		return nil
+	}
+	return q.disk.Ack()
+}
+
+// Restore puts jobs of a snapshot back into the memory buffer.
+// Jobs queued to disk after the snapshot are dropped: crawling
+// the jobs of the snapshot and those dequeued from disk since
+// finds them again. Must be called before the queue is used.
+func (q *BufferedQueue) Restore(jobs []JobGob, gen int) error {
+	if err := q.dropAfter(gen); err != nil {
+		return err
+	}
+	q.gen = gen + 1
+
+	for _, gob := range jobs {
+		var job Job
+		gob.FromGob(&job)
+		q.buf.push(&job, -1)
+	}
+	atomic.AddInt64(q.queued, int64(len(jobs)))
+	q.notify()
+	return nil
+}
+
+// dropAfter removes the jobs on disk newer than gen
+// by cycling through the disk queue once
+func (q *BufferedQueue) dropAfter(gen int) error {
+	if q.disk == nil {
+		return nil
+	}
+	n := q.disk.Len()
+	var dropped int
+	for i := 0; i < n; i++ {
+		var job Job
+		if err := q.disk.Dequeue(&job); err != nil {
+			return err
+		}
+		if job.Gen > gen {
+			dropped++
+			continue
+		}
+		if err := q.disk.Enqueue(&job); err != nil {
+			return err
+		}
+	}
+	atomic.AddInt64(q.queued, -int64(dropped))
+	return q.disk.Ack()
+}
+
+// Suspend closes the queue but keeps the files on disk.
+func (q *BufferedQueue) Suspend() {
+	if q.setClosed() && q.disk != nil {
+		q.disk.Close()
+	}
+}
+
+type JobGob struct {
+	Uri string
+	Fails int
+	LastError string
+	Gen int
+}
+
+func (g *JobGob) ToGob(j *Job) {
+	g.Uri = j.UriStr
+	g.Fails = j.Fails
+	g.Gen = j.Gen
+	if j.LastError != nil {
+		g.LastError = j.LastError.Error()
+	}
+}
+
+func (g *JobGob) FromGob(j *Job) {
+	if err := j.Uri.Parse(g.Uri);
+		err != nil { panic(err) }
+	j.UriStr = g.Uri
+	j.Fails = g.Fails
+	j.Gen = g.Gen
+	if g.LastError != "" {
+		j.LastError = errorString(g.LastError)
+	}
+}
@@ -0,0 +1,572 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testJob(t *testing.T, path string) *Job {
+	job := &Job{UriStr: "http://example.org" + path}
+	if err := job.Uri.Parse(job.UriStr); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestQueueDequeueBlocks(t *testing.T) {
+	var queued int64
+	q, err := OpenQueue("", QueueGoque, -1, &queued)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobC := make(chan Job)
+	go func() {
+		job, err := q.Dequeue(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		jobC <- job
+	}()
+	select {
+	case <-jobC:
+		t.Fatal("Dequeued from an empty queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if err := q.Enqueue(testJob(t, "/a/")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case job := <-jobC:
+		if job.UriStr != "http://example.org/a/" {
+			t.Errorf("Unexpected job %s", job.UriStr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Dequeue not woken up")
+	}
+	if queued != 0 {
+		t.Errorf("Expected empty queue, %d queued", queued)
+	}
+}
+
+func TestQueueDequeueCancel(t *testing.T) {
+	var queued int64
+	q, _ := OpenQueue("", QueueGoque, -1, &queued)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20 * time.Millisecond)
+	defer cancel()
+	if _, err := q.Dequeue(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestQueueClose(t *testing.T) {
+	var queued int64
+	q, _ := OpenQueue("", QueueGoque, -1, &queued)
+	q.Enqueue(testJob(t, "/a/"))
+
+	errC := make(chan error)
+	go func() {
+		// Drain the queue and wait for more
+		q.Dequeue(context.Background())
+		_, err := q.Dequeue(context.Background())
+		errC <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	q.Close()
+
+	select {
+	case err := <-errC:
+		if err != ErrQueueClosed {
+			t.Errorf("Expected ErrQueueClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Dequeue not woken up")
+	}
+	if err := q.Enqueue(testJob(t, "/b/")); err != ErrQueueClosed {
+		t.Errorf("Enqueued after Close: %v", err)
+	}
+	if queued != 0 {
+		t.Errorf("Expected empty queue, %d queued", queued)
+	}
+}
+
+func TestQueueDisk(t *testing.T) {
+	for name, backend := range diskBackends {
+		t.Run(name, func(t *testing.T) {
+			testQueueDisk(t, backend)
+		})
+	}
+}
+
+func testQueueDisk(t *testing.T, backend QueueBackend) {
+	dir, err := ioutil.TempDir("", "od-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dataDir := filepath.Join(dir, "1")
+
+	var queued int64
+	q, err := OpenQueue(dataDir, backend, 1, &queued)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{"/a/", "/b/", "/c/"}
+	for _, path := range paths {
+		if err := q.Enqueue(testJob(t, path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if q.DiskLen() != 2 || q.Len() != 3 || queued != 3 {
+		t.Fatalf("Expected 2 of 3 jobs on disk, got %d of %d", q.DiskLen(), q.Len())
+	}
+	for _, path := range paths {
+		job, err := q.Dequeue(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.UriStr != "http://example.org" + path {
+			t.Errorf("Expected %s, got %s", path, job.UriStr)
+		}
+	}
+	if _, err := q.TryDequeue(); err == nil {
+		t.Error("Dequeued from an empty queue")
+	}
+
+	q.Close()
+	if _, err := os.Stat(dataDir); !os.IsNotExist(err) {
+		t.Errorf("Queue files not deleted: %v", err)
+	}
+}
+
+func TestQueueRestore(t *testing.T) {
+	for name, backend := range diskBackends {
+		t.Run(name, func(t *testing.T) {
+			testQueueRestore(t, backend)
+		})
+	}
+}
+
+func testQueueRestore(t *testing.T, backend QueueBackend) {
+	dir, err := ioutil.TempDir("", "od-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var queued int64
+	q, err := OpenQueue(dir, backend, 1, &queued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enqueue := func(paths ...string) {
+		for _, path := range paths {
+			if err := q.Enqueue(testJob(t, path)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	dequeue := func(paths ...string) {
+		for _, path := range paths {
+			job, err := q.TryDequeue()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if job.UriStr != "http://example.org" + path {
+				t.Errorf("Expected %s, got %s", path, job.UriStr)
+			}
+		}
+	}
+	enqueue("/a/", "/b/", "/c/")
+	dequeue("/a/", "/b/")
+	enqueue("/d/")
+	jobs, gen := q.Snapshot()
+	if err := q.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	// Crawled after the checkpoint
+	dequeue("/d/", "/c/")
+	enqueue("/d/1/", "/d/2/")
+	// Crash
+	q.Suspend()
+
+	queued = 0
+	q, err = OpenQueue(dir, backend, 1, &queued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err := q.Restore(jobs, gen); err != nil {
+		t.Fatal(err)
+	}
+	// /c/ wasn't acked yet
+	if q.Len() != 2 || queued != 2 {
+		t.Fatalf("Expected 2 jobs, got %d (%d queued)", q.Len(), queued)
+	}
+	dequeue("/d/", "/c/")
+
+	// Jobs of the resumed crawl are kept
+	enqueue("/d/", "/e/")
+	if _, gen2 := q.Snapshot(); gen2 <= gen {
+		t.Errorf("Generation %d not after %d", gen2, gen)
+	}
+	if err := q.dropAfter(gen + 1); err != nil || q.Len() != 2 {
+		t.Errorf("Expected 2 jobs, got %d (%v)", q.Len(), err)
+	}
+}
+
+func TestCrawlWorkersExit(t *testing.T) {
+	s := newCrawlTestServer()
+	defer s.Close()
+
+	c := New(Options{Workers: 4, JobBufferSize: -1})
+	var u fasturl.URL
+	u.Parse(s.URL + "/pub/")
+
+	// Finished crawl
+	o := c.NewOD(&Task{WebsiteId: 1, Url: s.URL + "/pub/"}, &u)
+	results := make(chan File)
+	go func() {
+		for range results {}
+	}()
+	if err := o.Start(context.Background(), results); err != nil {
+		t.Fatal(err)
+	}
+	o.Wait.Wait()
+	if !o.MarkFinished() {
+		t.Error("Finished crawl not marked finished")
+	}
+	// Too late to suspend
+	if suspended, _ := o.Suspend(func(s *State) error { return nil }); suspended {
+		t.Error("Suspended a finished crawl")
+	}
+	closeTimeout(t, o.Close)
+
+	// Suspended while paused
+	o = c.NewOD(&Task{WebsiteId: 2, Url: s.URL + "/pub/"}, &u)
+	o.Pause()
+	if err := o.Start(context.Background(), results); err != nil {
+		t.Fatal(err)
+	}
+	closeTimeout(t, func() error {
+		_, err := o.Suspend(func(s *State) error { return nil })
+		return err
+	})
+	// Releases the waiters
+	closeTimeout(t, func() error {
+		o.Wait.Wait()
+		return nil
+	})
+	if o.MarkFinished() {
+		t.Error("Suspended crawl marked finished")
+	}
+	close(results)
+}
+
+// closeTimeout fails if fn doesn't return in time
+func closeTimeout(t *testing.T, fn func() error) {
+	t.Helper()
+	errC := make(chan error)
+	go func() {
+		errC <- fn()
+	}()
+	select {
+	case err := <-errC:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Workers didn't exit")
+	}
+}
+
+var diskBackends = map[string]QueueBackend{
+	"goque": QueueGoque,
+	"bolt":  QueueBolt,
+}
+
+var allBackends = map[string]QueueBackend{
+	"memory": QueueMemory,
+	"goque":  QueueGoque,
+	"bolt":   QueueBolt,
+}
+
+// TestJobQueues runs the conformance tests against all backends
+func TestJobQueues(t *testing.T) {
+	tests := map[string]func(t *testing.T, backend QueueBackend, dir string){
+		"FIFO":       testJobQueueFIFO,
+		"Concurrent": testJobQueueConcurrent,
+		"Closed":     testJobQueueClosed,
+		"Reopen":     testJobQueueReopen,
+	}
+	for name, backend := range allBackends {
+		for testName, test := range tests {
+			backend, test := backend, test
+			t.Run(name + "/" + testName, func(t *testing.T) {
+				dir, err := ioutil.TempDir("", "od-queue")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(dir)
+				test(t, backend, dir)
+			})
+		}
+	}
+}
+
+func testJobQueueFIFO(t *testing.T, backend QueueBackend, dir string) {
+	q, err := OpenJobQueue(backend, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	var job Job
+	if err := q.Dequeue(&job); err != ErrQueueEmpty {
+		t.Fatalf("Expected ErrQueueEmpty, got %v", err)
+	}
+
+	// Interleaved, across growing and shrinking buffers
+	next, expected := 0, 0
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 100; i++ {
+			j := testJob(t, fmt.Sprintf("/%d/", next))
+			j.Fails = next % 3
+			j.LastError = errorString("error " + strconv.Itoa(next))
+			if err := q.Enqueue(j); err != nil {
+				t.Fatal(err)
+			}
+			next++
+		}
+		for i := 0; i < 70; i++ {
+			if err := q.Dequeue(&job); err != nil {
+				t.Fatal(err)
+			}
+			checkTestJob(t, &job, expected)
+			expected++
+		}
+		if err := q.Ack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if q.Len() != next - expected {
+		t.Errorf("Expected %d jobs, got %d", next - expected, q.Len())
+	}
+	for q.Len() > 0 {
+		if err := q.Dequeue(&job); err != nil {
+			t.Fatal(err)
+		}
+		checkTestJob(t, &job, expected)
+		expected++
+	}
+	if err := q.Dequeue(&job); err != ErrQueueEmpty {
+		t.Errorf("Expected ErrQueueEmpty, got %v", err)
+	}
+}
+
+func checkTestJob(t *testing.T, job *Job, i int) {
+	t.Helper()
+	path := fmt.Sprintf("/%d/", i)
+	if job.Uri.Path != path || job.UriStr != "http://example.org" + path ||
+		job.Fails != i % 3 || job.LastError == nil ||
+		job.LastError.Error() != "error " + strconv.Itoa(i) {
+		t.Fatalf("Expected job %d, got %+v", i, job)
+	}
+}
+
+func testJobQueueConcurrent(t *testing.T, backend QueueBackend, dir string) {
+	q, err := OpenJobQueue(backend, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	const producers, perProducer = 4, 250
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProducer; i++ {
+				if err := q.Enqueue(testJob(t, fmt.Sprintf("/%d/%d/", p, i))); err != nil {
+					t.Error(err)
+				}
+			}
+		}(p)
+	}
+
+	var m sync.Mutex
+	seen := make(map[string]bool)
+	var consumers sync.WaitGroup
+	done := make(chan struct{})
+	for c := 0; c < 4; c++ {
+		consumers.Add(1)
+		go func() {
+			defer consumers.Done()
+			for {
+				var job Job
+				err := q.Dequeue(&job)
+				if err == ErrQueueEmpty {
+					select {
+					case <-done:
+						if q.Len() == 0 {
+							return
+						}
+					default:
+					}
+					continue
+				} else if err != nil {
+					t.Error(err)
+					return
+				}
+				m.Lock()
+				if seen[job.UriStr] {
+					t.Errorf("Dequeued %s twice", job.UriStr)
+				}
+				seen[job.UriStr] = true
+				if len(seen) % 100 == 0 {
+					if err := q.Ack(); err != nil {
+						t.Error(err)
+					}
+				}
+				m.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	consumers.Wait()
+
+	if len(seen) != producers * perProducer {
+		t.Errorf("Expected %d jobs, got %d", producers * perProducer, len(seen))
+	}
+}
+
+func testJobQueueClosed(t *testing.T, backend QueueBackend, dir string) {
+	q, err := OpenJobQueue(backend, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Enqueue(testJob(t, "/a/"))
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var job Job
+	if err := q.Dequeue(&job); err != ErrQueueClosed {
+		t.Errorf("Dequeue: expected ErrQueueClosed, got %v", err)
+	}
+	if err := q.Enqueue(testJob(t, "/b/")); err != ErrQueueClosed {
+		t.Errorf("Enqueue: expected ErrQueueClosed, got %v", err)
+	}
+}
+
+func testJobQueueReopen(t *testing.T, backend QueueBackend, dir string) {
+	if backend == QueueMemory {
+		t.Skip("Not persistent")
+	}
+	q, err := OpenJobQueue(backend, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		j := testJob(t, fmt.Sprintf("/%d/", i))
+		j.Fails = i % 3
+		j.LastError = errorString("error " + strconv.Itoa(i))
+		q.Enqueue(j)
+	}
+	var job Job
+	q.Dequeue(&job)
+	if err := q.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	// Not acked, read again after reopening
+	q.Dequeue(&job)
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err = OpenJobQueue(backend, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if q.Len() != 2 {
+		t.Fatalf("Expected 2 jobs after reopening, got %d", q.Len())
+	}
+	for i := 1; i < 3; i++ {
+		if err := q.Dequeue(&job); err != nil {
+			t.Fatal(err)
+		}
+		checkTestJob(t, &job, i)
+	}
+}
+
+func TestEncodeJob(t *testing.T) {
+	for _, gob := range []JobGob{
+		{},
+		{Uri: "http://example.org/a/", Fails: 3, LastError: "http status 500", Gen: 2},
+		{Uri: strings.Repeat("x", 300), Fails: -1},
+	} {
+		var got JobGob
+		if err := decodeJob(encodeJob(&gob), &got); err != nil || got != gob {
+			t.Errorf("Expected %+v, got %+v (%v)", gob, got, err)
+		}
+	}
+	buf := encodeJob(&JobGob{Uri: "http://example.org/"})
+	var got JobGob
+	if err := decodeJob(buf[:len(buf)-2], &got); err != errBadJob {
+		t.Errorf("Expected errBadJob on truncated job, got %v", err)
+	}
+}
+
+// Fill a queue with b.N jobs and drain it, e.g. a million:
+//
+//	go test -run - -bench JobQueue -benchtime 1000000x ./crawler
+func BenchmarkJobQueue(b *testing.B) {
+	for name, backend := range allBackends {
+		backend := backend
+		b.Run(name, func(b *testing.B) {
+			dir, err := ioutil.TempDir("", "od-queue")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			q, err := OpenJobQueue(backend, dir)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer q.Close()
+
+			var job Job
+			job.UriStr = "http://example.org/pub/some/directory/file-000000.bin"
+			job.Uri.Parse(job.UriStr)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := q.Enqueue(&job); err != nil {
+					b.Fatal(err)
+				}
+			}
+			for i := 0; i < b.N; i++ {
+				if err := q.Dequeue(&job); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := q.Ack(); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package crawler
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	backoffMin = time.Second
+	backoffMax = 5 * time.Minute
+	// Time without rate limits before speeding up again
+	rampUpInterval = 10 * time.Second
+	// Rate limited attempts of a job that don't count towards
+	// Options.Retries if the server didn't say when to retry.
+	// A 503 often means the backend is down for good.
+	maxRateLimits = 3
+	// Backoff of a job after which rate limited
+	// attempts always count towards Options.Retries
+	maxRateLimitWait = 10 * time.Minute
+)
+
+// HostLimiter spaces out requests to hosts that answered
+// with "429 Too Many Requests" or "503 Service Unavailable".
+// It is shared by all workers of a task.
+type HostLimiter struct {
+	m     sync.Mutex
+	hosts map[string]*hostBackoff
+}
+
+type hostBackoff struct {
+	// Time between requests
+	delay   time.Duration
+	// Earliest time of the next request
+	next    time.Time
+	// Last time the delay changed
+	changed time.Time
+	// Lowest delay, from Crawl-delay
+	floor   time.Duration
+}
+
+// Reserve returns how long to wait before sending
+// a request to the host. If zero, the request may
+// be sent now and the next one is delayed.
+func (l *HostLimiter) Reserve(host string) time.Duration {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	b := l.hosts[host]
+	if b == nil {
+		return 0
+	}
+	now := time.Now()
+	if wait := b.next.Sub(now); wait > 0 {
+		return wait
+	}
+	b.next = now.Add(b.delay)
+	return 0
+}
+
+// SetMinDelay spaces out all requests to the host by at least d
+func (l *HostLimiter) SetMinDelay(host string, d time.Duration) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.hosts == nil {
+		l.hosts = make(map[string]*hostBackoff)
+	}
+	b := l.hosts[host]
+	if b == nil {
+		b = new(hostBackoff)
+		l.hosts[host] = b
+	}
+	b.floor = d
+	if b.delay < d {
+		b.delay = d
+	}
+	if next := time.Now().Add(d); next.After(b.next) {
+		b.next = next
+	}
+}
+
+// Backoff slows down requests to the host after a rate limit.
+// retryAfter is the delay requested by the server, if any.
+// Returns the time until the next request to the host.
+func (l *HostLimiter) Backoff(host string, retryAfter time.Duration) time.Duration {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.hosts == nil {
+		l.hosts = make(map[string]*hostBackoff)
+	}
+	b := l.hosts[host]
+	if b == nil {
+		b = new(hostBackoff)
+		l.hosts[host] = b
+	}
+
+	now := time.Now()
+	// Concurrent requests hitting the same limit
+	// only count once.
+	if now.After(b.next) {
+		b.delay *= 2
+		if b.delay < backoffMin {
+			b.delay = backoffMin
+		}
+		if b.delay > backoffMax {
+			b.delay = backoffMax
+		}
+		b.changed = now
+	}
+
+	wait := jitter(b.delay)
+	if retryAfter > backoffMax {
+		retryAfter = backoffMax
+	}
+	if retryAfter > wait {
+		wait = retryAfter
+	}
+	if next := now.Add(wait); next.After(b.next) {
+		b.next = next
+	}
+	return b.next.Sub(now)
+}
+
+// Success slowly speeds up requests to
+// the host after a successful request.
+func (l *HostLimiter) Success(host string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	b := l.hosts[host]
+	if b == nil {
+		return
+	}
+	now := time.Now()
+	if now.Sub(b.changed) < rampUpInterval {
+		return
+	}
+	b.delay = b.delay * 3 / 4
+	b.changed = now
+	if b.delay < b.floor {
+		b.delay = b.floor
+	}
+	if b.floor == 0 && b.delay < backoffMin / 10 {
+		delete(l.hosts, host)
+	}
+}
+
+// MaxDelay returns the highest delay of all hosts.
+func (l *HostLimiter) MaxDelay() (max time.Duration) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	for _, b := range l.hosts {
+		if b.delay > max {
+			max = b.delay
+		}
+	}
+	return
+}
+
+// freeRateLimit checks if a rate limited attempt of job is retried
+// without counting towards Options.Retries. A 429 with Retry-After
+// is free until the job waited maxRateLimitWait, others only
+// maxRateLimits times.
+func freeRateLimit(job *Job, err *RateLimitError) bool {
+	if job.RateLimitWait >= maxRateLimitWait {
+		return false
+	}
+	if err.code == http.StatusTooManyRequests && err.RetryAfter > 0 {
+		return true
+	}
+	return job.RateLimits < maxRateLimits
+}
+
+// Randomize d by ±25%
+func jitter(d time.Duration) time.Duration {
+	return d * 3 / 4 + time.Duration(rand.Int63n(int64(d) / 2 + 1))
+}
+
+// parseRetryAfter reads the Retry-After header,
+// either in seconds or as an HTTP date.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseUint(v, 10, 32); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0
+	}
+	if d := t.Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
@@ -0,0 +1,125 @@
+package crawler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		in  string
+		out time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{" 5 ", 5 * time.Second},
+		{"Wed, 02 Jan 2019 03:05:05 GMT", time.Minute},
+		{"Wednesday, 02-Jan-19 03:04:15 GMT", 10 * time.Second},
+		{"Wed, 02 Jan 2019 03:00:00 GMT", 0},
+		{"-1", 0},
+		{"soon", 0},
+	}
+	for _, test := range tests {
+		if got := parseRetryAfter(test.in, now); got != test.out {
+			t.Errorf(`parseRetryAfter("%s") = %s, want %s`, test.in, got, test.out)
+		}
+	}
+}
+
+func TestHostLimiter(t *testing.T) {
+	var l HostLimiter
+	if wait := l.Reserve("example.org"); wait != 0 {
+		t.Fatalf("Expected no delay, got %s", wait)
+	}
+
+	l.Backoff("example.org", 0)
+	wait := l.Reserve("example.org")
+	if wait < backoffMin * 3 / 4 || wait > backoffMin * 5 / 4 {
+		t.Errorf("Expected ~%s delay, got %s", backoffMin, wait)
+	}
+	if wait := l.Reserve("other.org"); wait != 0 {
+		t.Errorf("Other host should not be limited, got %s", wait)
+	}
+
+	// Concurrent rate limits don't stack
+	l.Backoff("example.org", 0)
+	if d := l.MaxDelay(); d != backoffMin {
+		t.Errorf("Expected delay %s, got %s", backoffMin, d)
+	}
+
+	// Retry-After wins if longer
+	l.Backoff("example.org", time.Hour)
+	if wait := l.Reserve("example.org"); wait < backoffMax - time.Second {
+		t.Errorf("Expected capped Retry-After, got %s", wait)
+	}
+
+	// Next limit after the wait doubles the delay
+	l.hosts["example.org"].next = time.Now().Add(-time.Second)
+	l.Backoff("example.org", 0)
+	if d := l.MaxDelay(); d != 2 * backoffMin {
+		t.Errorf("Expected delay %s, got %s", 2 * backoffMin, d)
+	}
+
+	// Ramp up after a quiet period
+	l.hosts["example.org"].changed = time.Now().Add(-rampUpInterval)
+	l.Success("example.org")
+	if d := l.MaxDelay(); d != 2 * backoffMin * 3 / 4 {
+		t.Errorf("Expected delay %s, got %s", 2 * backoffMin * 3 / 4, d)
+	}
+	l.Success("example.org")
+	if d := l.MaxDelay(); d != 2 * backoffMin * 3 / 4 {
+		t.Errorf("Ramped up too fast: %s", d)
+	}
+}
+
+func TestCrawlRateLimitRetry(t *testing.T) {
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		if r.URL.Path == "/pub/" {
+			w.Write([]byte(`<a href="a.bin">a.bin</a>`))
+		} else {
+			w.Header().Set("Content-Length", "100")
+		}
+	}))
+	defer s.Close()
+
+	// Rate limits don't use up the retries
+	c := New(Options{JobBufferSize: -1, Retries: 0})
+	result, got := crawlTest(t, c, Task{WebsiteId: 1, Url: s.URL + "/pub/"})
+	if result.StatusCode != "success" || len(got) != 1 || result.ErrorCount != 0 {
+		t.Errorf("Unexpected result %+v, files %v", result, got)
+	}
+}
+
+func TestFreeRateLimit(t *testing.T) {
+	retryAfter := &RateLimitError{code: 429, RetryAfter: time.Minute}
+	unavailable := &RateLimitError{code: 503}
+
+	var job Job
+	for i := 0; i < maxRateLimits; i++ {
+		if !freeRateLimit(&job, unavailable) {
+			t.Fatalf("Attempt %d counted", i)
+		}
+		job.RateLimits++
+	}
+	if freeRateLimit(&job, unavailable) {
+		t.Error("503 free after the first attempts")
+	}
+	if !freeRateLimit(&job, retryAfter) {
+		t.Error("Retry-After counted")
+	}
+
+	// Waited long enough
+	job.RateLimitWait = maxRateLimitWait
+	if freeRateLimit(&job, retryAfter) {
+		t.Error("Retry-After free after the max wait")
+	}
+}
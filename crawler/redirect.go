@@ -0,0 +1,135 @@
+package crawler
+
+import (
+	"errors"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"github.com/valyala/fasthttp"
+	"strings"
+	"time"
+)
+
+var ErrRedirectLoop      = errors.New("redirect loop")
+var ErrTooManyRedirects  = errors.New("too many redirects")
+var ErrRedirectOutOfScope = errors.New("redirect out of scope")
+
+type RedirectPolicy int
+
+const (
+	// Follow redirects to the host of the task
+	RedirectSameHost RedirectPolicy = iota
+	// Follow redirects below the base URL of the task
+	RedirectSamePrefix
+	// Follow all redirects (files only)
+	RedirectAny
+)
+
+// doRequest sends the request to u and follows redirects
+// as configured. The last response is stored in res.
+// Returns the URL that answered the request.
+func (o *OD) doRequest(req *fasthttp.Request, res *fasthttp.Response, u fasturl.URL, base *fasturl.URL) (final fasturl.URL, err error) {
+	c := o.crawler
+	var visited []string
+	var authRetried bool
+	for {
+		uriStr := u.String()
+		req.SetRequestURI(uriStr)
+
+		// Credentials, headers and cookies of the task,
+		// only for its own host
+		session := o.sessionFor(u.Host)
+		var header fasthttp.RequestHeader
+		if session != nil {
+			req.Header.CopyTo(&header)
+			session.apply(req)
+		}
+
+		release := o.acquireServer(u.Host)
+		start := time.Now()
+		err = o.route.client.Do(req, res)
+		release()
+		c.observeRequest(string(req.Header.Method()), err, res.StatusCode(),
+			start, len(res.Header.Header()) + len(res.Body()))
+		if session != nil {
+			header.CopyTo(&req.Header)
+		}
+		if err != nil {
+			return u, err
+		}
+
+		if session != nil {
+			session.update(res)
+			if res.StatusCode() == fasthttp.StatusUnauthorized &&
+				!authRetried && session.challenge(res) {
+				authRetried = true
+				continue
+			}
+		}
+
+		if !isRedirect(res.StatusCode()) || c.opts.MaxRedirects <= 0 {
+			return u, nil
+		}
+		location := string(res.Header.Peek("Location"))
+		if location == "" {
+			return u, nil
+		}
+
+		visited = append(visited, uriStr)
+		if len(visited) > c.opts.MaxRedirects {
+			return u, ErrTooManyRedirects
+		}
+
+		var next fasturl.URL
+		err = u.ParseRel(&next, location)
+		if err != nil {
+			return u, err
+		}
+		nextStr := next.String()
+		for _, prev := range visited {
+			if prev == nextStr {
+				return u, ErrRedirectLoop
+			}
+		}
+		if !redirectAllowed(c.opts.RedirectPolicy, base, &next) {
+			return u, ErrRedirectOutOfScope
+		}
+
+		u = next
+	}
+}
+
+func isRedirect(status int) bool {
+	switch status {
+	case fasthttp.StatusMovedPermanently,
+		fasthttp.StatusFound,
+		fasthttp.StatusSeeOther,
+		fasthttp.StatusTemporaryRedirect,
+		fasthttp.StatusPermanentRedirect:
+		return true
+	default:
+		return false
+	}
+}
+
+func redirectAllowed(policy RedirectPolicy, base *fasturl.URL, u *fasturl.URL) bool {
+	if u.Scheme != fasturl.SchemeHTTP && u.Scheme != fasturl.SchemeHTTPS {
+		return false
+	}
+	switch policy {
+	case RedirectAny:
+		return true
+	case RedirectSamePrefix:
+		return inScope(base, u)
+	default:
+		return u.Host == base.Host
+	}
+}
+
+// inScope checks if u is below the base URL of a task.
+// Switching between HTTP and HTTPS is allowed.
+func inScope(base *fasturl.URL, u *fasturl.URL) bool {
+	if u.Scheme != fasturl.SchemeHTTP && u.Scheme != fasturl.SchemeHTTPS {
+		return false
+	}
+	return u.Host == base.Host &&
+		strings.HasPrefix(u.Path, base.Path)
+}
@@ -0,0 +1,137 @@
+package crawler
+
+import (
+	"github.com/terorie/od-database-crawler/fasturl"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newRedirectTestServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pub/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body><a href="file.bin">file.bin</a></body></html>`))
+	})
+	mux.HandleFunc("/pub/file.bin", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1234")
+	})
+	mux.HandleFunc("/pub/dir", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/pub/", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/pub/moved.bin", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/pub/file.bin", http.StatusFound)
+	})
+	mux.HandleFunc("/pub/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/pub/loop2", http.StatusFound)
+	})
+	mux.HandleFunc("/pub/loop2", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/pub/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/pub/outside/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/other/", http.StatusFound)
+	})
+	mux.HandleFunc("/other/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body><a href="secret.bin">secret.bin</a></body></html>`))
+	})
+	mux.HandleFunc("/pub/cdn.bin", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://cdn.invalid/file.bin", http.StatusFound)
+	})
+	return httptest.NewServer(mux)
+}
+
+func redirectTestJob(t *testing.T, w *WorkerContext, path string) (newJobs []Job, f File, err error) {
+	t.Helper()
+	var job Job
+	job.Uri = w.OD.BaseUri
+	job.Uri.Path = path
+	job.UriStr = job.Uri.String()
+	newJobs, _, err = w.DoJob(&job, &f)
+	return
+}
+
+func TestRedirects(t *testing.T) {
+	s := newRedirectTestServer()
+	defer s.Close()
+
+	c := New(Options{
+		MaxRedirects:   5,
+		RedirectPolicy: RedirectSameHost,
+	})
+	var u fasturl.URL
+	if err := u.Parse(s.URL + "/pub/"); err != nil {
+		t.Fatal(err)
+	}
+	od := c.NewOD(&Task{Url: s.URL + "/pub/"}, &u)
+	w := &od.WCtx
+
+	// Missing trailing slash
+	newJobs, f, err := redirectTestJob(t, w, "/pub/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.IsDir || len(newJobs) != 1 || newJobs[0].Uri.Path != "/pub/" {
+		t.Errorf("Expected redirect to directory, got %+v", newJobs)
+	}
+
+	// Redirected file
+	_, f, err = redirectTestJob(t, w, "/pub/moved.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.IsDir || f.Name != "moved.bin" || f.Size != 1234 {
+		t.Errorf("Unexpected file %+v", f)
+	}
+
+	// Loop
+	_, _, err = redirectTestJob(t, w, "/pub/loop")
+	if err != ErrRedirectLoop {
+		t.Errorf("Expected redirect loop, got %v", err)
+	}
+
+	// Directory leaving the site
+	_, _, err = redirectTestJob(t, w, "/pub/outside/")
+	if err != ErrRedirectOutOfScope {
+		t.Errorf("Expected out of scope error, got %v", err)
+	}
+
+	// Other host
+	_, _, err = redirectTestJob(t, w, "/pub/cdn.bin")
+	if err != ErrRedirectOutOfScope {
+		t.Errorf("Expected out of scope error, got %v", err)
+	}
+
+	// Don't follow
+	c.opts.MaxRedirects = 0
+	_, _, err = redirectTestJob(t, w, "/pub/moved.bin")
+	if httpErr, ok := err.(*HttpError); !ok || httpErr.Code != http.StatusFound {
+		t.Errorf("Expected HTTP 302 error, got %v", err)
+	}
+}
+
+func TestRedirectPolicy(t *testing.T) {
+	var base fasturl.URL
+	if err := base.Parse("http://example.org/pub/"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url    string
+		policy RedirectPolicy
+		allow  bool
+	}{
+		{"https://example.org/pub/a", RedirectSamePrefix, true},
+		{"http://example.org/other/a", RedirectSamePrefix, false},
+		{"http://example.org/other/a", RedirectSameHost, true},
+		{"http://cdn.example.org/a", RedirectSameHost, false},
+		{"http://cdn.example.org/a", RedirectAny, true},
+		{"ftp://example.org/pub/a", RedirectAny, false},
+	}
+	for _, test := range tests {
+		var u fasturl.URL
+		if err := u.Parse(test.url); err != nil {
+			t.Fatal(err)
+		}
+		if got := redirectAllowed(test.policy, &base, &u); got != test.allow {
+			t.Errorf("redirectAllowed(%s, %d) = %v", test.url, test.policy, got)
+		}
+	}
+}
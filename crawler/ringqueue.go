@@ -0,0 +1,111 @@
+package crawler
+
+import "sync"
+
+// Smallest capacity of a ringQueue
+const minRingSize = 16
+
+// ringQueue keeps jobs in memory in a ring buffer.
+// It grows as needed and shrinks again as jobs are
+// taken out, so a drained queue doesn't hold on to
+// the memory of its peak.
+type ringQueue struct {
+	m      sync.Mutex
+	jobs   []Job
+	// Index of the first job
+	head   int
+	n      int
+	closed bool
+}
+
+func newRingQueue() *ringQueue {
+	return new(ringQueue)
+}
+
+func (r *ringQueue) Enqueue(job *Job) error {
+	if !r.push(job, -1) {
+		return ErrQueueClosed
+	}
+	return nil
+}
+
+// push adds a job if less than max jobs are queued.
+// A negative max means no limit.
+func (r *ringQueue) push(job *Job, max int) bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.closed || (max >= 0 && r.n >= max) {
+		return false
+	}
+	if r.n == len(r.jobs) {
+		size := 2 * len(r.jobs)
+		if size < minRingSize {
+			size = minRingSize
+		}
+		r.resize(size)
+	}
+	r.jobs[(r.head + r.n) % len(r.jobs)] = *job
+	r.n++
+	return true
+}
+
+func (r *ringQueue) Dequeue(job *Job) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.closed {
+		return ErrQueueClosed
+	}
+	if r.n == 0 {
+		return ErrQueueEmpty
+	}
+	*job = r.jobs[r.head]
+	r.jobs[r.head] = Job{}
+	r.head = (r.head + 1) % len(r.jobs)
+	r.n--
+	if len(r.jobs) > minRingSize && r.n < len(r.jobs) / 4 {
+		r.resize(len(r.jobs) / 2)
+	}
+	return nil
+}
+
+// Ack does nothing, dequeued jobs are gone
+func (r *ringQueue) Ack() error {
+	return nil
+}
+
+func (r *ringQueue) Len() int {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.n
+}
+
+// Close drops all jobs
+func (r *ringQueue) Close() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.closed = true
+	r.jobs = nil
+	r.head, r.n = 0, 0
+	return nil
+}
+
+// each calls fn with every job in order
+func (r *ringQueue) each(fn func(job *Job)) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	for i := 0; i < r.n; i++ {
+		fn(&r.jobs[(r.head + i) % len(r.jobs)])
+	}
+}
+
+// resize moves the jobs to a new buffer of size
+func (r *ringQueue) resize(size int) {
+	jobs := make([]Job, size)
+	for i := 0; i < r.n; i++ {
+		jobs[i] = r.jobs[(r.head + i) % len(r.jobs)]
+	}
+	r.jobs = jobs
+	r.head = 0
+}
@@ -0,0 +1,312 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"github.com/valyala/fasthttp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// With Options.Robots, the robots.txt of a site is fetched
+// before the first request. Disallowed links are skipped
+// and Crawl-delay spaces out the requests of the task.
+
+// Status code of tasks whose base URL is disallowed
+const StatusDisallowed = "disallowed by robots.txt"
+
+var ErrDisallowed = errors.New("disallowed by robots.txt")
+
+// Server error on robots.txt, the job is retried
+// instead of assuming a complete disallow
+var ErrRobotsUnavailable = errors.New("robots.txt unavailable")
+
+// Product token of the crawler in robots.txt
+const RobotsAgent = "od-database-crawler"
+
+const (
+	// Time to keep robots.txt of a host
+	robotsTTL = 24 * time.Hour
+	// Longest Crawl-delay honored
+	maxCrawlDelay = time.Minute
+	// Rest of the file is ignored (RFC 9309)
+	maxRobotsSize = 500 * 1024
+)
+
+// Robots holds the rules of a robots.txt for our User-Agent.
+// The nil value allows everything.
+type Robots struct {
+	rules      []robotsRule
+	CrawlDelay time.Duration
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// robotsCache holds the robots.txt of hosts
+type robotsCache struct {
+	m     sync.Mutex
+	hosts map[string]*robotsEntry
+}
+
+type robotsEntry struct {
+	robots  *Robots
+	fetched time.Time
+}
+
+// Robots fetches the robots.txt of the site until it succeeds,
+// nil if Options.Robots is off.
+func (o *OD) Robots() (*Robots, error) {
+	o.robotsM.Lock()
+	defer o.robotsM.Unlock()
+	if o.robotsDone || !o.crawler.opts.Robots {
+		return o.robots, nil
+	}
+	robots, err := o.GetRobots(&o.BaseUri)
+	if err != nil { return nil, err }
+	o.robots, o.robotsDone = robots, true
+	if robots != nil && robots.CrawlDelay > 0 {
+		o.WCtx.Limiter.SetMinDelay(o.BaseUri.Host, robots.CrawlDelay)
+	}
+	return robots, nil
+}
+
+// robotsAllowed checks a link against the robots.txt of the site
+// and counts the links skipped.
+func (o *OD) robotsAllowed(robots *Robots, u *fasturl.URL) bool {
+	if robots.Allowed(u.Path) {
+		return true
+	}
+	atomic.AddUint64(&o.Result.SkippedByPolicy, 1)
+	return false
+}
+
+// GetRobots returns the robots.txt rules of the host of u,
+// from the cache of the crawler if fetched recently.
+// Server errors are not cached.
+func (o *OD) GetRobots(u *fasturl.URL) (*Robots, error) {
+	c := o.crawler
+	if u.Scheme != fasturl.SchemeHTTP && u.Scheme != fasturl.SchemeHTTPS {
+		return nil, nil
+	}
+	key := fasturl.Schemes[u.Scheme] + "://" + u.Host
+
+	c.robots.m.Lock()
+	if entry := c.robots.hosts[key]; entry != nil && time.Since(entry.fetched) < robotsTTL {
+		c.robots.m.Unlock()
+		return entry.robots, nil
+	}
+	c.robots.m.Unlock()
+
+	robots, err := o.fetchRobots(u)
+	if err != nil { return nil, err }
+
+	c.robots.m.Lock()
+	defer c.robots.m.Unlock()
+	if c.robots.hosts == nil {
+		c.robots.hosts = make(map[string]*robotsEntry)
+	}
+	c.robots.hosts[key] = &robotsEntry{robots, time.Now()}
+	return robots, nil
+}
+
+func (o *OD) fetchRobots(u *fasturl.URL) (*Robots, error) {
+	c := o.crawler
+	root := *u
+	root.Path = "/"
+	robotsUri := root
+	robotsUri.Path = "/robots.txt"
+
+	req := fasthttp.AcquireRequest()
+	if c.opts.UserAgent != "" {
+		req.Header.SetUserAgent(c.opts.UserAgent)
+	}
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+
+	_, err := o.doRequest(req, res, robotsUri, &root)
+	fasthttp.ReleaseRequest(req)
+
+	switch {
+	case err != nil:
+		c.log.WithError(err).
+			WithField("url", robotsUri.String()).
+			Warn("Failed to get robots.txt")
+		return nil, nil
+	case res.StatusCode() >= 500:
+		// Complete disallow until it's back (RFC 9309)
+		c.log.WithField("url", robotsUri.String()).
+			Warnf("Failed to get robots.txt: http status %d", res.StatusCode())
+		return nil, ErrRobotsUnavailable
+	case res.StatusCode() != fasthttp.StatusOK:
+		// No robots.txt
+		return nil, nil
+	}
+
+	body := res.Body()
+	if len(body) > maxRobotsSize {
+		body = body[:maxRobotsSize]
+	}
+	return ParseRobots(body, c.opts.UserAgent), nil
+}
+
+// ParseRobots reads the rules of the groups matching the
+// product token of userAgent, see robotsAgent. Falls back
+// to the "*" groups.
+func ParseRobots(body []byte, userAgent string) *Robots {
+	agent := robotsAgent(userAgent)
+
+	type group struct {
+		agents []string
+		robots Robots
+	}
+	var groups []*group
+	var current *group
+	// User-agent lines after rules start a new group
+	inRules := true
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:colon]))
+		value := strings.TrimSpace(line[colon+1:])
+
+		switch key {
+		case "user-agent":
+			if inRules || current == nil {
+				current = new(group)
+				groups = append(groups, current)
+				inRules = false
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inRules = true
+			if current == nil || value == "" {
+				continue
+			}
+			current.robots.rules = append(current.robots.rules,
+				robotsRule{allow: key == "allow", pattern: value})
+		case "crawl-delay":
+			inRules = true
+			if current == nil {
+				continue
+			}
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil || seconds < 0 {
+				continue
+			}
+			delay := time.Duration(seconds * float64(time.Second))
+			if delay > maxCrawlDelay {
+				delay = maxCrawlDelay
+			}
+			current.robots.CrawlDelay = delay
+		}
+	}
+
+	// Groups of the same agent are combined
+	has := func(g *group, name string) bool {
+		for _, a := range g.agents {
+			if a == name {
+				return true
+			}
+		}
+		return false
+	}
+	var own, wildcard []*group
+	for _, g := range groups {
+		if has(g, agent) {
+			own = append(own, g)
+		} else if has(g, "*") {
+			wildcard = append(wildcard, g)
+		}
+	}
+	if len(own) == 0 {
+		own = wildcard
+	}
+	if len(own) == 0 {
+		return nil
+	}
+	robots := new(Robots)
+	for _, g := range own {
+		robots.rules = append(robots.rules, g.robots.rules...)
+		if robots.CrawlDelay == 0 {
+			robots.CrawlDelay = g.robots.CrawlDelay
+		}
+	}
+	return robots
+}
+
+// robotsAgent returns the product token of userAgent in lower case,
+// e.g. "curl" for "curl/7.61". Browser-like User-Agents such as the
+// default one ("Mozilla/5.0 ...") are matched as RobotsAgent.
+func robotsAgent(userAgent string) string {
+	token := strings.TrimSpace(userAgent)
+	if i := strings.IndexAny(token, "/ "); i >= 0 {
+		token = token[:i]
+	}
+	token = strings.ToLower(token)
+	if token == "" || token == "mozilla" {
+		return RobotsAgent
+	}
+	return token
+}
+
+// Allowed checks if path may be crawled.
+// The longest matching rule wins, Allow on a tie.
+func (r *Robots) Allowed(path string) bool {
+	if r == nil {
+		return true
+	}
+	allow := true
+	longest := -1
+	for _, rule := range r.rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		n := len(rule.pattern)
+		if n > longest || (n == longest && rule.allow) {
+			allow, longest = rule.allow, n
+		}
+	}
+	return allow
+}
+
+// robotsMatch matches a path against a rule with
+// "*" wildcards and a "$" end anchor
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = pattern[:len(pattern)-1]
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	last := len(parts) - 1
+	for i := 1; i <= last; i++ {
+		if anchored && i == last {
+			return strings.HasSuffix(rest, parts[i])
+		}
+		j := strings.Index(rest, parts[i])
+		if j < 0 {
+			return false
+		}
+		rest = rest[j+len(parts[i]):]
+	}
+	return !anchored || rest == ""
+}
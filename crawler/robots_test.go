@@ -0,0 +1,183 @@
+package crawler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testRobots = `# Comment
+User-agent: *
+Disallow: /private/
+Allow: /private/public/
+
+User-agent: googlebot
+User-agent: od-database-crawler
+Disallow: /pub/secret/  # no trailing comment
+Disallow: /*.iso$
+Allow: /pub/secret/ok.bin
+Crawl-delay: 0.5
+
+User-agent: od-database
+Disallow: /
+`
+
+func TestParseRobots(t *testing.T) {
+	const ua = "Mozilla/5.0 (X11; od-database-crawler) Gecko/20100101 Firefox/52.0"
+	robots := ParseRobots([]byte(testRobots), ua)
+	if robots == nil {
+		t.Fatal("No group matched")
+	}
+	if robots.CrawlDelay != 500 * time.Millisecond {
+		t.Errorf("Unexpected Crawl-delay %s", robots.CrawlDelay)
+	}
+	tests := map[string]bool{
+		"/":                       true,
+		"/private/":               true,
+		"/pub/secret/":            false,
+		"/pub/secret/a.bin":       false,
+		"/pub/secret/ok.bin":      true,
+		"/pub/debian.iso":         false,
+		"/pub/debian.iso.torrent": true,
+	}
+	for path, expected := range tests {
+		if robots.Allowed(path) != expected {
+			t.Errorf("%s: expected allowed = %v", path, expected)
+		}
+	}
+
+	// Falls back to *
+	robots = ParseRobots([]byte(testRobots), "curl/7.61")
+	if robots.Allowed("/private/") || !robots.Allowed("/private/public/a") ||
+		!robots.Allowed("/pub/debian.iso") || robots.CrawlDelay != 0 {
+		t.Errorf("Unexpected rules %+v", robots)
+	}
+
+	// Nothing for us
+	if ParseRobots([]byte("User-agent: googlebot\nDisallow: /\n"), ua) != nil {
+		t.Error("Matched another User-agent")
+	}
+	// Aimed at browsers, not at the browser-like default
+	if ParseRobots([]byte("User-agent: mozilla\nDisallow: /\n"), ua) != nil {
+		t.Error("Matched a browser User-agent")
+	}
+	// Only the product token counts
+	robots = ParseRobots([]byte("User-agent: od\nDisallow: /\n\nUser-agent: curl\nDisallow: /a/\n"),
+		"curl/7.61 (od)")
+	if robots == nil || !robots.Allowed("/") || robots.Allowed("/a/") {
+		t.Errorf("Unexpected rules %+v", robots)
+	}
+}
+
+func TestRobotsMatch(t *testing.T) {
+	tests := []struct{
+		pattern, path string
+		match         bool
+	}{
+		{"/", "/a", true},
+		{"/a", "/abc", true},
+		{"/a$", "/abc", false},
+		{"/a$", "/a", true},
+		{"/*.php", "/x/index.php?a=1", true},
+		{"/*.php$", "/x/index.php?a=1", false},
+		{"/*/b/*.c$", "/a/b/b/x.c", true},
+		{"/*a*a$", "/a", false},
+		{"/x", "/", false},
+	}
+	for _, test := range tests {
+		if robotsMatch(test.pattern, test.path) != test.match {
+			t.Errorf(`"%s" on "%s": expected %v`, test.pattern, test.path, test.match)
+		}
+	}
+}
+
+func TestCrawlRobots(t *testing.T) {
+	var m sync.Mutex
+	var times []time.Time
+	var robotsFetches int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		defer m.Unlock()
+		switch r.URL.Path {
+		case "/robots.txt":
+			robotsFetches++
+			times = append(times, time.Now())
+			w.Write([]byte("User-agent: od-database-crawler\nDisallow: /pub/secret/\nCrawl-delay: 0.1\n"))
+		case "/pub/":
+			w.Write([]byte(`<a href="a.bin">a.bin</a> <a href="b.bin">b.bin</a> <a href="secret/">secret/</a>`))
+		case "/pub/a.bin", "/pub/b.bin":
+			times = append(times, time.Now())
+			w.Header().Set("Content-Length", "100")
+		default:
+			t.Errorf("Disallowed request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer s.Close()
+
+	c := New(Options{
+		Workers:       4,
+		JobBufferSize: -1,
+		UserAgent:     "od-database-crawler/1.0",
+		Robots:        true,
+	})
+	result, got := crawlTest(t, c, Task{WebsiteId: 1, Url: s.URL + "/pub/"})
+	sort.Strings(got)
+	if result.StatusCode != "success" || len(got) != 2 || result.SkippedByPolicy != 1 {
+		t.Errorf("Unexpected result %+v, files %v", result, got)
+	}
+	// Probes of the listing format don't count
+	for i := 1; i < len(times); i++ {
+		// Crawl-delay
+		if gap := times[i].Sub(times[i-1]); gap < 90 * time.Millisecond {
+			t.Errorf("Requests %s apart", gap)
+		}
+	}
+
+	// Cached, base URL disallowed
+	result, _ = crawlTest(t, c, Task{WebsiteId: 2, Url: s.URL + "/pub/secret/"})
+	if result.StatusCode != StatusDisallowed || result.SkippedByPolicy != 1 || robotsFetches != 1 {
+		t.Errorf("Unexpected result %+v, %d robots.txt fetches", result, robotsFetches)
+	}
+}
+
+func TestCrawlRobotsServerError(t *testing.T) {
+	var m sync.Mutex
+	var robotsFetches int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		defer m.Unlock()
+		switch r.URL.Path {
+		case "/robots.txt":
+			robotsFetches++
+			if robotsFetches == 1 {
+				// Once, not cached
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte("User-agent: *\nDisallow: /pub/secret/\n"))
+		case "/pub/":
+			w.Write([]byte(`<a href="a.bin">a.bin</a> <a href="secret/">secret/</a>`))
+		case "/pub/a.bin":
+			w.Header().Set("Content-Length", "100")
+		default:
+			t.Errorf("Disallowed request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer s.Close()
+
+	c := New(Options{
+		Workers:       2,
+		JobBufferSize: -1,
+		Retries:       2,
+		Robots:        true,
+	})
+	result, got := crawlTest(t, c, Task{WebsiteId: 1, Url: s.URL + "/pub/"})
+	if result.StatusCode != "success" || len(got) != 1 || robotsFetches != 2 {
+		t.Errorf("Unexpected result %+v, files %v, %d robots.txt fetches", result, got, robotsFetches)
+	}
+}
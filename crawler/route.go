@@ -0,0 +1,103 @@
+package crawler
+
+import (
+	"github.com/terorie/od-database-crawler/fasturl"
+	"github.com/valyala/fasthttp"
+	"net"
+)
+
+// Every task has its own HTTP client, proxy and session, so
+// settings of one task never apply to another, not even to
+// one on the same host, and pooled connections never outlive
+// the proxy choice of their task. Credentials, headers and
+// cookies only go to the host of the task, nothing leaks
+// after a redirect.
+
+// route holds how a task reaches its site
+type route struct {
+	// Host name of the task
+	host     string
+	// Proxy of the task, nil to use the crawler's
+	proxy    *Proxy
+	// Invalid proxy of the task, nothing is sent
+	proxyErr error
+	session  *Session
+	client   *fasthttp.Client
+}
+
+// initRoute sets up the client, proxy and session of the task
+func (o *OD) initRoute() {
+	c := o.crawler
+	r := &o.route
+	r.host = hostName(o.BaseUri.Host)
+	if o.Task.Proxy != "" {
+		r.proxy, r.proxyErr = ParseProxy(o.Task.Proxy)
+	}
+	r.session = newSession(&o.Task)
+	r.client = c.newClient(o.proxyForAddr)
+}
+
+// checkRoute checks that the site can be
+// reached through the proxy of the task
+func (o *OD) checkRoute() error {
+	addr := hostPort(&o.BaseUri)
+	proxy, err := o.proxyFor(o.route.host)
+	if err != nil || proxy == nil {
+		return err
+	}
+	return proxy.Check(addr, o.crawler.opts.DialTimeout)
+}
+
+// proxyFor returns the proxy to reach host, nil if direct.
+// The proxy of the task applies to all its requests.
+func (o *OD) proxyFor(host string) (*Proxy, error) {
+	if o.route.proxyErr != nil {
+		return nil, o.route.proxyErr
+	}
+	if o.route.proxy != nil {
+		return o.route.proxy, nil
+	}
+	return o.crawler.proxyFor(host)
+}
+
+// proxyForAddr returns the proxy to reach addr (host:port)
+func (o *OD) proxyForAddr(addr string) (*Proxy, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	return o.proxyFor(host)
+}
+
+// sessionFor returns the session of the task
+// if host is the host of the task, else nil
+func (o *OD) sessionFor(host string) *Session {
+	if hostName(host) == o.route.host {
+		return o.route.session
+	}
+	return nil
+}
+
+// hostPort returns the host and port of u,
+// with the default port of the scheme if unset
+func hostPort(u *fasturl.URL) string {
+	if _, _, err := net.SplitHostPort(u.Host); err == nil {
+		return u.Host
+	}
+	port := "80"
+	switch u.Scheme {
+	case fasturl.SchemeHTTPS:
+		port = "443"
+	case fasturl.SchemeFTP:
+		port = "21"
+	}
+	return net.JoinHostPort(u.Host, port)
+}
+
+// hostName strips the port from host
+func hostName(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		return name
+	}
+	return host
+}
@@ -0,0 +1,217 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/terorie/od-database-crawler/dates"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"github.com/valyala/fasthttp"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// S3-compatible buckets (AWS, MinIO, GCS XML API) answer with
+// ListBucketResult XML instead of an HTML listing. Directories
+// are listed with ListObjectsV2 and the delimiter "/", which
+// returns one level of the key tree per request.
+
+// S3Bucket is a bucket found at a site
+type S3Bucket struct {
+	// URL listing the bucket, ends with "/"
+	Root fasturl.URL
+}
+
+type s3ListResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified string
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+}
+
+// S3 returns the bucket of the base URL.
+// Returns nil if it isn't on S3 or not probed yet.
+func (o *OD) S3() *S3Bucket {
+	o.probeM.Lock()
+	defer o.probeM.Unlock()
+	return o.s3
+}
+
+// s3Headers checks for the Server or x-amz-* headers of S3 servers
+func s3Headers(h *fasthttp.ResponseHeader) bool {
+	server := strings.ToLower(string(h.Server()))
+	amz := strings.Contains(server, "amazons3") || strings.Contains(server, "minio")
+	h.VisitAll(func(key, _ []byte) {
+		if len(key) > 6 && strings.EqualFold(string(key[:6]), "x-amz-") {
+			amz = true
+		}
+	})
+	return amz
+}
+
+// DetectS3 looks for the root of an S3 bucket containing u.
+// The bucket is either the host (virtual-hosted style) or
+// the first path segment (path style).
+func (o *OD) DetectS3(u *fasturl.URL) *S3Bucket {
+	if u.Scheme != fasturl.SchemeHTTP && u.Scheme != fasturl.SchemeHTTPS {
+		return nil
+	}
+
+	roots := []string{u.Path}
+	if u.Path != "/" {
+		roots = append(roots, "/")
+		if i := strings.IndexByte(u.Path[1:], '/'); i >= 0 && i + 2 < len(u.Path) {
+			roots = append(roots, u.Path[:i + 2])
+		}
+	}
+
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+
+	for _, root := range roots {
+		b := &S3Bucket{Root: *u}
+		b.Root.Path = root
+		err := o.s3Request(b.listUri("", "", 0), res)
+		if err != nil {
+			return nil
+		}
+		body := res.Body()
+		if res.StatusCode() == fasthttp.StatusOK &&
+			bytes.Contains(body, []byte("<ListBucketResult")) {
+			return b
+		}
+		if !bytes.Contains(body, []byte("<Error>")) {
+			// Not an S3 server
+			return nil
+		}
+		// S3 error (e.g. NoSuchKey), not the bucket root
+	}
+	return nil
+}
+
+// GetDirS3 lists a directory of a bucket.
+// Files are returned directly with size and date.
+func (o *OD) GetDirS3(b *S3Bucket, j *Job, f *File) (links []fasturl.URL, files []File, err error) {
+	f.IsDir = true
+	f.Name = path.Base(j.Uri.Path)
+
+	if !strings.HasPrefix(j.Uri.Path, b.Root.Path) {
+		return nil, nil, ErrRedirectOutOfScope
+	}
+	prefix := fasturl.PathUnescape(strings.TrimPrefix(j.Uri.Path, b.Root.Path))
+
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+
+	var token string
+	for {
+		err = o.s3Request(b.listUri(prefix, token, -1), res)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = checkResponse(res)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var list s3ListResult
+		if err = xml.Unmarshal(res.Body(), &list); err != nil {
+			return nil, nil, err
+		}
+
+		for _, p := range list.CommonPrefixes {
+			if link, ok := b.link(p.Prefix); ok {
+				if !strings.HasSuffix(link.Path, "/") {
+					link.Path += "/"
+				}
+				links = append(links, link)
+			}
+		}
+		for _, obj := range list.Contents {
+			if obj.Key == prefix || strings.HasSuffix(obj.Key, "/") {
+				// Directory placeholder
+				continue
+			}
+			link, ok := b.link(obj.Key)
+			if !ok {
+				continue
+			}
+			entry := DirEntry{Link: link, Size: obj.Size}
+			if t, conf := dates.Parse(obj.LastModified); conf != dates.Invalid {
+				entry.MTime = t.Unix()
+			}
+			entry.setConfidence()
+			files = append(files, entry.File())
+		}
+
+		if !list.IsTruncated || list.NextContinuationToken == "" {
+			return
+		}
+		token = list.NextContinuationToken
+	}
+}
+
+// listUri returns the ListObjectsV2 URL,
+// maxKeys < 0 means the server default.
+func (b *S3Bucket) listUri(prefix, token string, maxKeys int) string {
+	q := url.Values{}
+	q.Set("list-type", "2")
+	q.Set("delimiter", "/")
+	if prefix != "" {
+		q.Set("prefix", prefix)
+	}
+	if token != "" {
+		q.Set("continuation-token", token)
+	}
+	if maxKeys >= 0 {
+		q.Set("max-keys", fmt.Sprint(maxKeys))
+	}
+	return b.Root.String() + "?" + q.Encode()
+}
+
+// link returns the URL of a key
+func (b *S3Bucket) link(key string) (link fasturl.URL, ok bool) {
+	if key == "" || strings.Contains(key, "//") {
+		// Can't be represented as a path
+		return link, false
+	}
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		if segment == "." || segment == ".." {
+			return link, false
+		}
+		segments[i] = url.PathEscape(segment)
+	}
+	link = b.Root
+	link.Path += strings.Join(segments, "/")
+	return link, true
+}
+
+func (o *OD) s3Request(uri string, res *fasthttp.Response) error {
+	c := o.crawler
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI(uri)
+	if c.opts.UserAgent != "" {
+		req.Header.SetUserAgent(c.opts.UserAgent)
+	}
+	if session := o.sessionFor(string(req.URI().Host())); session != nil {
+		session.apply(req)
+	}
+
+	release := o.acquireServer(string(req.URI().Host()))
+	start := time.Now()
+	err := o.route.client.Do(req, res)
+	release()
+	c.observeRequest("GET", err, res.StatusCode(),
+		start, len(res.Header.Header()) + len(res.Body()))
+	return err
+}
@@ -0,0 +1,125 @@
+package crawler
+
+import (
+	"github.com/terorie/od-database-crawler/fasturl"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Canned ListObjectsV2 pages by prefix and continuation token
+var s3TestPages = map[string]string {
+	"|": `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+<Name>bucket</Name><Prefix></Prefix><KeyCount>2</KeyCount><MaxKeys>2</MaxKeys>
+<Delimiter>/</Delimiter><IsTruncated>true</IsTruncated>
+<NextContinuationToken>1ueGcxLPRx1Tr/XYExHnhbYLgveDs2J/wm36Hy4vbOwM=</NextContinuationToken>
+<Contents><Key>README.txt</Key><LastModified>Sat, 01 Sep 2018 10:00:00 GMT</LastModified>
+<ETag>&quot;d41d8cd98f00b204e9800998ecf8427e&quot;</ETag><Size>120</Size><StorageClass>STANDARD</StorageClass></Contents>
+<CommonPrefixes><Prefix>photos/</Prefix></CommonPrefixes>
+</ListBucketResult>`,
+	"|1ueGcxLPRx1Tr/XYExHnhbYLgveDs2J/wm36Hy4vbOwM=": `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+<Name>bucket</Name><Prefix></Prefix><KeyCount>1</KeyCount><MaxKeys>2</MaxKeys>
+<Delimiter>/</Delimiter><IsTruncated>false</IsTruncated>
+<Contents><Key>track 01.flac</Key><LastModified>2018-09-03T12:30:00.000Z</LastModified>
+<Size>31457280</Size></Contents>
+</ListBucketResult>`,
+	"photos/|": `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+<Name>bucket</Name><Prefix>photos/</Prefix><KeyCount>3</KeyCount><MaxKeys>1000</MaxKeys>
+<Delimiter>/</Delimiter><IsTruncated>false</IsTruncated>
+<Contents><Key>photos/</Key><LastModified>2018-09-01T10:00:00.000Z</LastModified><Size>0</Size></Contents>
+<Contents><Key>photos/cat.jpg</Key><LastModified>2018-09-01T10:00:00.000Z</LastModified><Size>2048</Size></Contents>
+<Contents><Key>photos//odd.jpg</Key><LastModified>2018-09-01T10:00:00.000Z</LastModified><Size>1</Size></Contents>
+</ListBucketResult>`,
+}
+
+const s3TestError = `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`
+
+// newS3TestServer serves the bucket path-style at /bucket/
+func newS3TestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/bucket/" || q.Get("list-type") != "2" {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(s3TestError))
+			return
+		}
+		if q.Get("delimiter") != "/" {
+			t.Errorf("Missing delimiter in %s", r.URL)
+		}
+		page, ok := s3TestPages[q.Get("prefix") + "|" + q.Get("continuation-token")]
+		if !ok || q.Get("max-keys") == "0" {
+			page = s3TestPages["photos/|"]
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(page))
+	}))
+}
+
+func crawlS3Test(t *testing.T, rawUrl string) (files []string) {
+	t.Helper()
+	result, got := crawlTest(t, New(Options{JobBufferSize: -1}), Task{Url: rawUrl})
+	if result.StatusCode != "success" || result.ListingFormat != "s3" {
+		t.Errorf("Bucket not detected: %+v", result)
+	}
+	for _, file := range got {
+		files = append(files, fasturl.PathUnescape(file))
+	}
+	sort.Strings(files)
+	return
+}
+
+func TestCrawlS3(t *testing.T) {
+	s := newS3TestServer(t)
+	defer s.Close()
+
+	files := crawlS3Test(t, s.URL + "/bucket/")
+	expected := []string{"bucket/README.txt", "bucket/photos/cat.jpg", "bucket/track 01.flac"}
+	if strings.Join(files, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, got %v", expected, files)
+	}
+}
+
+func TestCrawlS3Prefix(t *testing.T) {
+	s := newS3TestServer(t)
+	defer s.Close()
+
+	files := crawlS3Test(t, s.URL + "/bucket/photos/")
+	if len(files) != 1 || files[0] != "bucket/photos/cat.jpg" {
+		t.Errorf("Unexpected files %v", files)
+	}
+}
+
+func TestS3Files(t *testing.T) {
+	s := newS3TestServer(t)
+	defer s.Close()
+
+	var u fasturl.URL
+	if err := u.Parse(s.URL + "/bucket/"); err != nil {
+		t.Fatal(err)
+	}
+	bucket := &S3Bucket{Root: u}
+	var f File
+	o := New(Options{}).NewOD(&Task{Url: u.String()}, &u)
+	_, files, err := o.GetDirS3(bucket, &Job{Uri: u}, &f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 files, got %+v", files)
+	}
+	if files[1].Name != "track%2001.flac" || files[1].Size != 31457280 ||
+		files[1].MTime != 1535977800 || files[1].Path != "bucket" {
+		t.Errorf("Unexpected file %+v", files[1])
+	}
+	// Some S3 clones send HTTP dates
+	if files[0].MTime != 1535796000 {
+		t.Errorf("Unexpected date of %+v", files[0])
+	}
+}
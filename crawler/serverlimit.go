@@ -0,0 +1,144 @@
+package crawler
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Several tasks can target the same server, e.g. subdirectories
+// of one site or mirrors behind one IP. Options.MaxHostConnections
+// and MaxHostRate apply per server across all tasks, servers are
+// told apart by their resolved IP.
+
+const (
+	// Time to keep resolved IPs of hosts
+	serverKeyTTL = 5 * time.Minute
+)
+
+// serverLimiter limits concurrent requests
+// and the request rate per server
+type serverLimiter struct {
+	m       sync.Mutex
+	servers map[string]*serverSlot
+	// Resolved IPs by host name
+	keys    map[string]serverKey
+}
+
+type serverSlot struct {
+	// Semaphore of concurrent requests
+	conns chan struct{}
+	// Earliest time of the next request
+	next  time.Time
+	// Requests waiting or running
+	users int
+}
+
+type serverKey struct {
+	key     string
+	expires time.Time
+}
+
+// acquireServer blocks until a request of the task to host
+// may be sent. The returned func must be called after the request.
+func (o *OD) acquireServer(host string) (release func()) {
+	c := o.crawler
+	if c.opts.MaxHostConnections <= 0 && c.opts.MaxHostRate <= 0 {
+		return func() {}
+	}
+	// Hosts behind a proxy aren't resolved,
+	// the proxy might not want us to.
+	p, err := o.proxyFor(hostName(host))
+	proxied := err != nil || p != nil
+	key := c.serverKey(host, proxied)
+	l := &c.servers
+
+	l.m.Lock()
+	if l.servers == nil {
+		l.servers = make(map[string]*serverSlot)
+	}
+	slot := l.servers[key]
+	if slot == nil {
+		slot = new(serverSlot)
+		if c.opts.MaxHostConnections > 0 {
+			slot.conns = make(chan struct{}, c.opts.MaxHostConnections)
+		}
+		l.servers[key] = slot
+	}
+	slot.users++
+	l.m.Unlock()
+
+	if slot.conns != nil {
+		slot.conns <- struct{}{}
+	}
+
+	if c.opts.MaxHostRate > 0 {
+		interval := time.Duration(float64(time.Second) / c.opts.MaxHostRate)
+		l.m.Lock()
+		now := time.Now()
+		if slot.next.Before(now) {
+			slot.next = now
+		}
+		wait := slot.next.Sub(now)
+		slot.next = slot.next.Add(interval)
+		l.m.Unlock()
+		time.Sleep(wait)
+	}
+
+	return func() {
+		if slot.conns != nil {
+			<-slot.conns
+		}
+		l.m.Lock()
+		slot.users--
+		if slot.users == 0 && time.Now().After(slot.next) {
+			delete(l.servers, key)
+		}
+		l.m.Unlock()
+	}
+}
+
+// serverKey returns the resolved IP of host,
+// the host name if proxied.
+func (c *Crawler) serverKey(host string, proxied bool) string {
+	name := hostName(host)
+	if proxied || net.ParseIP(name) != nil {
+		return name
+	}
+
+	l := &c.servers
+	l.m.Lock()
+	cached, ok := l.keys[name]
+	l.m.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.key
+	}
+
+	key := name
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.DialTimeout)
+	ips, _ := net.DefaultResolver.LookupIPAddr(ctx, name)
+	cancel()
+	// Lowest IP, the order changes with round-robin DNS
+	for i, ip := range ips {
+		if s := ip.IP.String(); i == 0 || s < key {
+			key = s
+		}
+	}
+
+	l.m.Lock()
+	if l.keys == nil {
+		l.keys = make(map[string]serverKey)
+	}
+	now := time.Now()
+	if len(l.keys) >= 1024 {
+		for host, cached := range l.keys {
+			if now.After(cached.expires) {
+				delete(l.keys, host)
+			}
+		}
+	}
+	l.keys[name] = serverKey{key, now.Add(serverKeyTTL)}
+	l.m.Unlock()
+	return key
+}
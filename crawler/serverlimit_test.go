@@ -0,0 +1,103 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newBusyServer lists a number of files and records
+// the highest number of concurrent requests
+func newBusyServer(files int, maxActive *int32) *httptest.Server {
+	var active int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			max := atomic.LoadInt32(maxActive)
+			if n <= max || atomic.CompareAndSwapInt32(maxActive, max, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		if strings.HasSuffix(r.URL.Path, "/") {
+			var b strings.Builder
+			for i := 0; i < files; i++ {
+				name := strconv.Itoa(i) + ".bin"
+				b.WriteString(`<a href="` + name + `">` + name + `</a>`)
+			}
+			w.Write([]byte(b.String()))
+			return
+		}
+		w.Header().Set("Content-Length", "100")
+	}))
+}
+
+func TestServerConnectionLimit(t *testing.T) {
+	var maxActive int32
+	s := newBusyServer(10, &maxActive)
+	defer s.Close()
+
+	c := New(Options{
+		Workers:            4,
+		JobBufferSize:      -1,
+		MaxHostConnections: 1,
+	})
+
+	// Two tasks, same server under different names
+	var wg sync.WaitGroup
+	for i, rawUrl := range []string{
+		s.URL + "/a/",
+		strings.Replace(s.URL, "127.0.0.1", "localhost", 1) + "/b/",
+	} {
+		wg.Add(1)
+		go func(id uint64, rawUrl string) {
+			defer wg.Done()
+			files := make(chan File)
+			go func() {
+				for range files {}
+			}()
+			result, err := c.Crawl(context.Background(), Task{WebsiteId: id, Url: rawUrl}, files)
+			close(files)
+			if err != nil || result.FileCount != 10 {
+				t.Errorf("Unexpected result %+v (%v)", result, err)
+			}
+		}(uint64(i), rawUrl)
+	}
+	wg.Wait()
+
+	if max := atomic.LoadInt32(&maxActive); max != 1 {
+		t.Errorf("Expected one connection at a time, got %d", max)
+	}
+	if len(c.servers.servers) != 0 {
+		t.Errorf("Leaked %d server slots", len(c.servers.servers))
+	}
+}
+
+func TestServerRateLimit(t *testing.T) {
+	var maxActive int32
+	s := newBusyServer(10, &maxActive)
+	defer s.Close()
+
+	c := New(Options{
+		Workers:       4,
+		JobBufferSize: -1,
+		MaxHostRate:   100,
+	})
+	start := time.Now()
+	result, _ := crawlTest(t, c, Task{WebsiteId: 1, Url: s.URL + "/pub/"})
+	if result.FileCount != 10 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	// Listing, WebDAV and S3 probes and ten files
+	if elapsed := time.Since(start); elapsed < 110 * time.Millisecond {
+		t.Errorf("13 requests took %s at 100 per second", elapsed)
+	}
+}
@@ -0,0 +1,236 @@
+package crawler
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"hash"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session holds the credentials, extra headers and cookies
+// of a task. Cookies set by the site are sent back on the
+// following requests of the task.
+type Session struct {
+	username string
+	password string
+	headers  map[string]string
+
+	m       sync.Mutex
+	cookies map[string]string
+	// Digest challenge of the site, nil for Basic auth
+	digest  *digestChallenge
+	// Digest nonce count
+	nc      uint32
+}
+
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+}
+
+func newSession(t *Task) *Session {
+	s := &Session{
+		username: t.Username,
+		password: t.Password,
+		headers:  t.Headers,
+		cookies:  make(map[string]string),
+	}
+	// fasturl drops userinfo, read it from the task
+	if s.username == "" {
+		taskUrl, err := url.Parse(t.Url)
+		if err == nil && taskUrl.User != nil {
+			s.username = taskUrl.User.Username()
+			s.password, _ = taskUrl.User.Password()
+		}
+	}
+	return s
+}
+
+// apply adds the headers, cookies and credentials to req
+func (s *Session) apply(req *fasthttp.Request) {
+	for key, value := range s.headers {
+		req.Header.Set(key, value)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	for key, value := range s.cookies {
+		req.Header.SetCookie(key, value)
+	}
+
+	switch {
+	case s.username == "" && s.password == "":
+	case s.digest != nil:
+		req.Header.Set("Authorization", s.digestAuth(
+			string(req.Header.Method()), string(req.URI().RequestURI())))
+	default:
+		auth := s.username + ":" + s.password
+		req.Header.Set("Authorization",
+			"Basic " + base64.StdEncoding.EncodeToString([]byte(auth)))
+	}
+}
+
+// update stores the cookies set by res
+func (s *Session) update(res *fasthttp.Response) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	res.Header.VisitAllCookie(func(key, value []byte) {
+		var cookie fasthttp.Cookie
+		if err := cookie.ParseBytes(value); err != nil {
+			return
+		}
+		expire := cookie.Expire()
+		if len(cookie.Value()) == 0 ||
+			(expire != fasthttp.CookieExpireUnlimited && expire.Before(time.Now())) {
+			delete(s.cookies, string(key))
+		} else {
+			s.cookies[string(key)] = string(cookie.Value())
+		}
+	})
+}
+
+// challenge reads the Digest challenge of a 401 response.
+// Returns true if the request should be sent again.
+func (s *Session) challenge(res *fasthttp.Response) bool {
+	if s.username == "" && s.password == "" {
+		return false
+	}
+	var digest *digestChallenge
+	res.Header.VisitAll(func(key, value []byte) {
+		if digest != nil || !strings.EqualFold(string(key), "WWW-Authenticate") {
+			return
+		}
+		digest = parseDigestChallenge(string(value))
+	})
+	if digest == nil {
+		return false
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.digest = digest
+	s.nc = 0
+	return true
+}
+
+func parseDigestChallenge(v string) *digestChallenge {
+	if len(v) < 7 || !strings.EqualFold(v[:7], "Digest ") {
+		return nil
+	}
+	params := parseAuthParams(v[7:])
+	d := &digestChallenge{
+		realm:     params["realm"],
+		nonce:     params["nonce"],
+		opaque:    params["opaque"],
+		algorithm: params["algorithm"],
+	}
+	if d.nonce == "" {
+		return nil
+	}
+	switch strings.ToUpper(d.algorithm) {
+	case "", "MD5", "SHA-256":
+	default:
+		return nil
+	}
+	for _, qop := range strings.Split(params["qop"], ",") {
+		if strings.TrimSpace(qop) == "auth" {
+			d.qop = "auth"
+		}
+	}
+	return d
+}
+
+// parseAuthParams splits `a=1, b="x, y"` into its values
+func parseAuthParams(v string) map[string]string {
+	params := make(map[string]string)
+	for {
+		v = strings.TrimLeft(v, " \t,")
+		eq := strings.IndexByte(v, '=')
+		if eq <= 0 {
+			return params
+		}
+		key := strings.ToLower(strings.TrimSpace(v[:eq]))
+		v = strings.TrimLeft(v[eq+1:], " \t")
+
+		var value string
+		if strings.HasPrefix(v, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(v) && v[i] != '"'; i++ {
+				if v[i] == '\\' && i+1 < len(v) {
+					i++
+				}
+				b.WriteByte(v[i])
+			}
+			value = b.String()
+			if i < len(v) {
+				// Closing quote
+				i++
+			}
+			v = v[i:]
+		} else {
+			end := strings.IndexByte(v, ',')
+			if end < 0 {
+				end = len(v)
+			}
+			value = strings.TrimSpace(v[:end])
+			v = v[end:]
+		}
+		params[key] = value
+	}
+}
+
+// digestAuth builds the Authorization header (RFC 7616).
+// Must be called with s.m held.
+func (s *Session) digestAuth(method, uri string) string {
+	d := s.digest
+	var h func() hash.Hash = md5.New
+	if strings.EqualFold(d.algorithm, "SHA-256") {
+		h = sha256.New
+	}
+	sum := func(parts ...string) string {
+		hh := h()
+		hh.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+
+	ha1 := sum(s.username, d.realm, s.password)
+	ha2 := sum(method, uri)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username="%s", realm="%s", nonce="%s", uri="%s"`,
+		quoteAuth(s.username), quoteAuth(d.realm), quoteAuth(d.nonce), quoteAuth(uri))
+	if d.qop != "" {
+		s.nc++
+		nc := fmt.Sprintf("%08x", s.nc)
+		var cnonceBytes [8]byte
+		rand.Read(cnonceBytes[:])
+		cnonce := hex.EncodeToString(cnonceBytes[:])
+		fmt.Fprintf(&b, `, qop=%s, nc=%s, cnonce="%s", response="%s"`,
+			d.qop, nc, cnonce, sum(ha1, d.nonce, nc, cnonce, d.qop, ha2))
+	} else {
+		fmt.Fprintf(&b, `, response="%s"`, sum(ha1, d.nonce, ha2))
+	}
+	if d.algorithm != "" {
+		fmt.Fprintf(&b, `, algorithm=%s`, d.algorithm)
+	}
+	if d.opaque != "" {
+		fmt.Fprintf(&b, `, opaque="%s"`, quoteAuth(d.opaque))
+	}
+	return b.String()
+}
+
+func quoteAuth(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v)
+}
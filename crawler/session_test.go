@@ -0,0 +1,201 @@
+package crawler
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCrawlSession(t *testing.T) {
+	var m sync.Mutex
+	var leaked []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		for _, key := range []string{"Authorization", "Referer", "Cookie"} {
+			if r.Header.Get(key) != "" {
+				leaked = append(leaked, key)
+			}
+		}
+		m.Unlock()
+		w.Header().Set("Content-Length", "300")
+	}))
+	defer other.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pub/", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+		w.Write([]byte(`<html><body>
+<a href="a.bin">a.bin</a>
+<a href="ext.bin">ext.bin</a>
+</body></html>`))
+	})
+	mux.HandleFunc("/pub/a.bin", func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "abc" {
+			http.Error(w, "no session", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Length", "100")
+	})
+	mux.HandleFunc("/pub/ext.bin", func(w http.ResponseWriter, r *http.Request) {
+		// Same address, different host name
+		otherUrl := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+		http.Redirect(w, r, otherUrl + "/ext.bin", http.StatusFound)
+	})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "user" || pass != "pass" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="od"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Referer") != "http://example.org/" {
+			http.Error(w, "hotlinking", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	c := New(Options{
+		JobBufferSize:  -1,
+		MaxRedirects:   5,
+		RedirectPolicy: RedirectAny,
+	})
+	result, got := crawlTest(t, c, Task{
+		WebsiteId: 1,
+		Url:       strings.Replace(s.URL, "http://", "http://user:pass@", 1) + "/pub/",
+		Headers:   map[string]string{"Referer": "http://example.org/"},
+	})
+	sort.Strings(got)
+	if result.StatusCode != "success" || len(got) != 2 ||
+		got[0] != "pub/a.bin" || got[1] != "pub/ext.bin" {
+		t.Errorf("Unexpected result %+v, files %v", result, got)
+	}
+	if len(leaked) != 0 {
+		t.Errorf("Sent %v to another host", leaked)
+	}
+}
+
+func TestCrawlSessionPerTask(t *testing.T) {
+	var m sync.Mutex
+	var leaked []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/a/") {
+			user, _, ok := r.BasicAuth()
+			if !ok || user != "a" || r.Header.Get("Referer") != "http://a.example.org/" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "a", Path: "/"})
+		} else {
+			m.Lock()
+			for _, key := range []string{"Authorization", "Referer", "Cookie"} {
+				if r.Header.Get(key) != "" {
+					leaked = append(leaked, key)
+				}
+			}
+			m.Unlock()
+		}
+		if strings.HasSuffix(r.URL.Path, "/") {
+			w.Write([]byte(`<a href="1.bin">1.bin</a><a href="2.bin">2.bin</a><a href="3.bin">3.bin</a>`))
+		} else {
+			w.Header().Set("Content-Length", "100")
+		}
+	}))
+	defer s.Close()
+
+	// Both tasks on the same host at the same time
+	c := New(Options{JobBufferSize: -1, Workers: 2})
+	var wg sync.WaitGroup
+	results := make([]TaskResult, 2)
+	tasks := []Task{{
+		WebsiteId: 1,
+		Url:       strings.Replace(s.URL, "http://", "http://a:pass@", 1) + "/a/",
+		Headers:   map[string]string{"Referer": "http://a.example.org/"},
+	}, {
+		WebsiteId: 2,
+		Url:       s.URL + "/b/",
+	}}
+	for i := range tasks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = crawlTest(t, c, tasks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		if result.StatusCode != "success" || result.FileCount != 3 {
+			t.Errorf("Task %d: unexpected result %+v", i, result)
+		}
+	}
+	if len(leaked) != 0 {
+		t.Errorf("Sent %v of one task with another", leaked)
+	}
+}
+
+func TestCrawlDigestAuth(t *testing.T) {
+	const realm, nonce = "od@example.org", "dcd98b7102dd2f0e8b11d0f600bfb0c093"
+	md5Hex := func(v string) string {
+		sum := md5.Sum([]byte(v))
+		return hex.EncodeToString(sum[:])
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if strings.HasPrefix(auth, "Digest ") {
+			p := parseAuthParams(auth[7:])
+			ha1 := md5Hex("user:" + realm + ":pass")
+			ha2 := md5Hex(r.Method + ":" + p["uri"])
+			expected := md5Hex(fmt.Sprintf("%s:%s:%s:%s:auth:%s", ha1, nonce, p["nc"], p["cnonce"], ha2))
+			if p["username"] == "user" && p["uri"] == r.URL.RequestURI() &&
+				p["response"] == expected && p["opaque"] == "xyz" {
+				if r.URL.Path == "/pub/" {
+					w.Write([]byte(`<a href="a.bin">a.bin</a>`))
+				} else {
+					w.Header().Set("Content-Length", "100")
+				}
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+			`Digest realm="%s", qop="auth,auth-int", nonce="%s", opaque="xyz"`, realm, nonce))
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer s.Close()
+
+	c := New(Options{JobBufferSize: -1})
+	result, got := crawlTest(t, c, Task{
+		WebsiteId: 1,
+		Url:       s.URL + "/pub/",
+		Username:  "user",
+		Password:  "pass",
+	})
+	if result.StatusCode != "success" || len(got) != 1 {
+		t.Errorf("Unexpected result %+v, files %v", result, got)
+	}
+}
+
+func TestParseAuthParams(t *testing.T) {
+	params := parseAuthParams(`realm="a, \"b\"", qop="auth,auth-int" ,nonce=123, stale=FALSE`)
+	expected := map[string]string{
+		"realm": `a, "b"`,
+		"qop":   "auth,auth-int",
+		"nonce": "123",
+		"stale": "FALSE",
+	}
+	if len(params) != len(expected) {
+		t.Errorf("Unexpected params %v", params)
+	}
+	for key, value := range expected {
+		if params[key] != value {
+			t.Errorf(`%s: expected "%s", got "%s"`, key, value, params[key])
+		}
+	}
+}
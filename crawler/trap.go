@@ -0,0 +1,125 @@
+package crawler
+
+import (
+	"bytes"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// Calendars, CMS pages and generated paths have anchors
+// pointing deeper forever. Links are checked against depth,
+// length and repetition limits, pages that don't look like
+// a listing aren't descended into, and tasks stop at the
+// file, dir and time limits.
+
+// Status codes of tasks stopped early
+const (
+	StatusNotListing = "not a listing"
+	StatusFileLimit  = "file limit reached"
+	StatusDirLimit   = "dir limit reached"
+	StatusTimeLimit  = "time limit reached"
+)
+
+// trapReason checks a link found in a listing.
+// Returns why it must not be followed or "".
+func (c *Crawler) trapReason(base, link *fasturl.URL) string {
+	if c.opts.MaxPathLength > 0 && len(link.Path) > c.opts.MaxPathLength {
+		return "path too long"
+	}
+
+	rel := strings.Trim(strings.TrimPrefix(link.Path, base.Path), "/")
+	if rel == "" {
+		return ""
+	}
+	segments := strings.Split(rel, "/")
+	if c.opts.MaxDepth > 0 && len(segments) > c.opts.MaxDepth {
+		return "too deep"
+	}
+
+	if c.opts.MaxRepeats > 0 {
+		seen := make(map[string]int, len(segments))
+		for _, segment := range segments {
+			seen[segment]++
+			if seen[segment] > c.opts.MaxRepeats {
+				return "repeated path segment"
+			}
+		}
+	}
+	return ""
+}
+
+// ListingScore estimates how much a page looks like a
+// directory index, from 0 (web page) to 1 (listing).
+// entries are the links ParseDir found on the page.
+func ListingScore(body []byte, format ListingFormat, entries []DirEntry) float64 {
+	if format != FormatGeneric ||
+		bytes.Contains(body, []byte("Index of /")) ||
+		bytes.Contains(body, []byte("Directory listing for /")) {
+		return 1
+	}
+
+	anchors := len(scanListing(body))
+	if len(entries) == 0 || anchors < len(entries) {
+		// Nothing to descend into or not HTML
+		return 1
+	}
+
+	var withMeta, fileLike int
+	for _, e := range entries {
+		if e.Size >= 0 || e.MTime != 0 {
+			withMeta++
+		}
+		if e.IsDir || path.Ext(e.Link.Path) != "" {
+			fileLike++
+		}
+	}
+
+	n := float64(len(entries))
+	// Listings link to little besides their entries
+	score := 0.5 * n / float64(anchors)
+	score += 0.3 * float64(withMeta) / n
+	score += 0.2 * float64(fileLike) / n
+
+	// Markup of web apps
+	lower := bytes.ToLower(body)
+	for _, tag := range []string{"<script", "<form", "<nav", "<iframe"} {
+		if bytes.Contains(lower, []byte(tag)) {
+			score -= 0.1
+		}
+	}
+
+	if score < 0 {
+		score = 0
+	}
+	return score
+}
+
+// stopAt ends the crawl early, keeping the results.
+// status replaces the status code of the task.
+func (o *OD) stopAt(status string) {
+	o.stopM.Lock()
+	defer o.stopM.Unlock()
+	if o.Stop(StopFinish) {
+		o.stopStatus = status
+	}
+}
+
+func (o *OD) stopStatusCode() string {
+	o.stopM.Lock()
+	defer o.stopM.Unlock()
+	return o.stopStatus
+}
+
+// checkLimits stops the crawl if the file or dir limit is reached.
+// The time limit is enforced by Start.
+func (o *OD) checkLimits() {
+	opts := &o.crawler.opts
+	switch {
+	case opts.MaxFiles > 0 && atomic.LoadUint64(&o.Result.FileCount) >= opts.MaxFiles:
+		o.stopAt(StatusFileLimit)
+	case opts.MaxDirs > 0 && atomic.LoadUint64(&o.dirCount) >= opts.MaxDirs:
+		o.stopAt(StatusDirLimit)
+	}
+}
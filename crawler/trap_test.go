@@ -0,0 +1,169 @@
+package crawler
+
+import (
+	"fmt"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrapReason(t *testing.T) {
+	c := New(Options{MaxDepth: 3, MaxPathLength: 40, MaxRepeats: 2})
+	var base fasturl.URL
+	if err := base.Parse("http://example.org/pub/"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct{
+		path   string
+		reason string
+	}{
+		{"/pub/a/b/c/", ""},
+		{"/pub/a/b/c/d/", "too deep"},
+		{"/pub/a/b/file.bin", ""},
+		{"/pub/" + strings.Repeat("x", 40), "path too long"},
+		{"/pub/2018/2018/", ""},
+		{"/pub/2018/01/2018/", ""},
+		{"/pub/a/a/a", "repeated path segment"},
+	}
+
+	for _, test := range tests {
+		link := base
+		link.Path = test.path
+		if got := c.trapReason(&base, &link); got != test.reason {
+			t.Errorf(`%s: expected "%s", got "%s"`, test.path, test.reason, got)
+		}
+	}
+}
+
+func TestListingScore(t *testing.T) {
+	var u fasturl.URL
+	if err := u.Parse("http://example.org/blog/"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct{
+		name    string
+		body    string
+		min     float64
+		max     float64
+	}{
+		{"nginx", nginxListing, 1, 1},
+		{"custom listing", `<html><body><h2>Files</h2>
+<a href="/">Home</a>
+<a href="a.iso">a.iso</a> 12-Jan-2018 10:00 4.7G
+<a href="b.iso">b.iso</a> 12-Jan-2018 10:00 4.7G
+<a href="docs/">docs/</a>
+</body></html>`, 0.6, 1},
+		{"cms page", cmsPage, 0, 0.3},
+	}
+
+	for _, test := range tests {
+		body := []byte(test.body)
+		entries, err := ParseDir(body, &u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		score := ListingScore(body, DetectListingFormat(body), entries)
+		if score < test.min || score > test.max {
+			t.Errorf("%s: score %.2f not in [%.2f, %.2f]", test.name, score, test.min, test.max)
+		}
+	}
+}
+
+// newTrapTestServer serves a calendar that links to the next
+// month forever and a listing with a file per day, a blog and
+// a listing that links to a blog.
+func newTrapTestServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cal/", func(w http.ResponseWriter, r *http.Request) {
+		// Different files per page, else it's a symlink loop
+		day := strings.Count(r.URL.Path, "/")
+		fmt.Fprintf(w, `<html><body><pre><a href="next/">next/</a> 01-Jan-2018 10:00 -
+<a href="day%d.txt">day%d.txt</a> 01-Jan-2018 10:00 10
+</pre></body></html>`, day, day)
+	})
+	mux.HandleFunc("/blog/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(cmsPage))
+	})
+	mux.HandleFunc("/pub/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body><pre><a href="blog/">blog/</a> 01-Jan-2018 10:00 -
+<a href="a.txt">a.txt</a> 01-Jan-2018 10:00 10
+</pre></body></html>`))
+	})
+	mux.HandleFunc("/pub/blog/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Replace(cmsPage, `"/blog/`, `"/pub/blog/`, -1)))
+	})
+	return httptest.NewServer(mux)
+}
+
+func crawlTrapTest(t *testing.T, opts Options, path string) (TaskResult, int) {
+	t.Helper()
+	s := newTrapTestServer()
+	defer s.Close()
+
+	opts.JobBufferSize = -1
+	opts.TrustListing = ConfidenceExact
+	result, files := crawlTest(t, New(opts), Task{Url: s.URL + path})
+	return result, len(files)
+}
+
+func TestCrawlMaxDepth(t *testing.T) {
+	result, files := crawlTrapTest(t, Options{MaxDepth: 4}, "/cal/")
+	if result.StatusCode != "success" || files != 5 {
+		t.Errorf("Expected 5 files, got %d (%s)", files, result.StatusCode)
+	}
+}
+
+func TestCrawlMaxFiles(t *testing.T) {
+	result, files := crawlTrapTest(t, Options{MaxFiles: 3}, "/cal/")
+	if result.StatusCode != StatusFileLimit || files != 3 {
+		t.Errorf("Expected file limit after 3 files, got %d (%s)", files, result.StatusCode)
+	}
+}
+
+func TestCrawlMaxDirs(t *testing.T) {
+	result, files := crawlTrapTest(t, Options{MaxDirs: 2}, "/cal/")
+	if result.StatusCode != StatusDirLimit || files != 2 {
+		t.Errorf("Expected dir limit after 2 dirs, got %d files (%s)", files, result.StatusCode)
+	}
+}
+
+func TestCrawlMaxDuration(t *testing.T) {
+	result, _ := crawlTrapTest(t, Options{MaxDuration: 100 * time.Millisecond}, "/cal/")
+	if result.StatusCode != StatusTimeLimit {
+		t.Errorf("Expected time limit, got %s", result.StatusCode)
+	}
+}
+
+func TestCrawlNotListing(t *testing.T) {
+	result, files := crawlTrapTest(t, Options{MinListingScore: 0.3}, "/blog/")
+	if result.StatusCode != StatusNotListing || files != 0 {
+		t.Errorf("Expected no files and not a listing, got %d (%s)", files, result.StatusCode)
+	}
+
+	// Below the site URL, it's just skipped
+	result, files = crawlTrapTest(t, Options{MinListingScore: 0.3}, "/pub/")
+	if result.StatusCode != "success" || files != 1 ||
+		result.SkippedNotListing != 1 || result.ErrorCount != 0 {
+		t.Errorf("Unexpected result %+v, %d files", result, files)
+	}
+}
+
+const cmsPage =
+`<!DOCTYPE html>
+<html>
+<head><title>My Blog</title><script src="/wp-includes/js/jquery.js"></script></head>
+<body>
+<nav><a href="/">Home</a> <a href="/about/">About</a> <a href="/contact/">Contact</a></nav>
+<form action="/search"><input name="q"></form>
+<article><h2><a href="/blog/hello-world">Hello world</a></h2>
+<p>Posted on <a href="/blog/2018/01/">January 2018</a> by <a href="/author/admin/">admin</a></p></article>
+<article><h2><a href="/blog/second-post">Second post</a></h2></article>
+<aside><a href="/blog/2018/02/">February 2018</a> <a href="/blog/2018/03/">March 2018</a>
+<a href="https://twitter.com/example">Twitter</a> <a href="/feed/">RSS</a></aside>
+</body>
+</html>`
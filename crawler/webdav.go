@@ -0,0 +1,203 @@
+package crawler
+
+import (
+	"encoding/xml"
+	"github.com/terorie/od-database-crawler/dates"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// WebDAV servers (RFC 4918) often have no usable HTML listing.
+// Directories are listed with PROPFIND instead, which returns
+// exact sizes and dates of all members in one request.
+
+const davPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop>
+<resourcetype/><getcontentlength/><getlastmodified/>
+</prop></propfind>`
+
+type davMultistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Status string  `xml:"DAV: status"`
+	Prop   davProp `xml:"DAV: prop"`
+}
+
+type davProp struct {
+	ResourceType struct {
+		Collection *struct{} `xml:"DAV: collection"`
+	} `xml:"DAV: resourcetype"`
+	ContentLength string `xml:"DAV: getcontentlength"`
+	LastModified  string `xml:"DAV: getlastmodified"`
+}
+
+// WebDAV returns whether the server supports
+// WebDAV listings, false if not probed yet.
+func (o *OD) WebDAV() bool {
+	o.probeM.Lock()
+	defer o.probeM.Unlock()
+	return o.dav
+}
+
+// davHeaders checks for the DAV header of WebDAV servers
+func davHeaders(h *fasthttp.ResponseHeader) bool {
+	return len(h.Peek("DAV")) > 0
+}
+
+// DetectWebDAV sends an OPTIONS request and looks
+// for WebDAV compliance class 1 in the DAV header.
+func (o *OD) DetectWebDAV(u *fasturl.URL) bool {
+	c := o.crawler
+	if u.Scheme != fasturl.SchemeHTTP && u.Scheme != fasturl.SchemeHTTPS {
+		return false
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("OPTIONS")
+	if c.opts.UserAgent != "" {
+		req.Header.SetUserAgent(c.opts.UserAgent)
+	}
+
+	res := fasthttp.AcquireResponse()
+	res.SkipBody = true
+	defer fasthttp.ReleaseResponse(res)
+
+	_, err := o.doRequest(req, res, *u, u)
+	fasthttp.ReleaseRequest(req)
+	if err != nil || res.StatusCode() >= 300 {
+		return false
+	}
+
+	dav := string(res.Header.Peek("DAV"))
+	for _, class := range strings.Split(dav, ",") {
+		if strings.TrimSpace(class) == "1" {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDirDAV lists a WebDAV collection. Like FTP listings,
+// the files are returned directly with size and date.
+func (o *OD) GetDirDAV(j *Job, f *File) (links []fasturl.URL, files []File, err error) {
+	c := o.crawler
+	base := &o.BaseUri
+	f.IsDir = true
+	f.Name = path.Base(j.Uri.Path)
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("PROPFIND")
+	req.Header.Set("Depth", "1")
+	req.Header.SetContentType(`application/xml; charset="utf-8"`)
+	req.SetBodyString(davPropfindBody)
+	if c.opts.UserAgent != "" {
+		req.Header.SetUserAgent(c.opts.UserAgent)
+	}
+
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+
+	final, err := o.doRequest(req, res, j.Uri, base)
+	fasthttp.ReleaseRequest(req)
+	if err != nil {
+		return
+	}
+
+	if res.StatusCode() != http.StatusMultiStatus {
+		err = checkResponse(res)
+		if err == nil {
+			err = &HttpError{Code: res.StatusCode()}
+		}
+		return
+	}
+
+	// Don't leave the directory tree
+	if !strings.HasSuffix(final.Path, "/") || !inScope(base, &final) {
+		return nil, nil, ErrRedirectOutOfScope
+	}
+
+	entries, err := ParseMultistatus(res.Body(), &final)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir || entry.Size < 0 {
+			// Size unknown, check the file itself
+			links = append(links, entry.Link)
+		} else {
+			files = append(files, entry.File())
+		}
+	}
+	return
+}
+
+// ParseMultistatus reads the members of a collection from
+// a PROPFIND response. The collection itself is skipped.
+func ParseMultistatus(body []byte, baseUri *fasturl.URL) (entries []DirEntry, err error) {
+	var ms davMultistatus
+	if err = xml.Unmarshal(body, &ms); err != nil {
+		return nil, err
+	}
+
+	for _, res := range ms.Responses {
+		var link fasturl.URL
+		if err := baseUri.ParseRel(&link, strings.TrimSpace(res.Href)); err != nil {
+			continue
+		}
+		if link.Scheme != baseUri.Scheme ||
+			link.Host != baseUri.Host ||
+			strings.TrimSuffix(link.Path, "/") == strings.TrimSuffix(baseUri.Path, "/") ||
+			!strings.HasPrefix(link.Path, baseUri.Path) {
+			continue
+		}
+
+		entry := DirEntry{
+			Link:       link,
+			Href:       res.Href,
+			Size:       -1,
+			Confidence: ConfidenceExact,
+		}
+		for _, ps := range res.Propstats {
+			if !davStatusOK(ps.Status) {
+				continue
+			}
+			prop := &ps.Prop
+			if prop.ResourceType.Collection != nil {
+				entry.IsDir = true
+			}
+			if size, err := strconv.ParseInt(strings.TrimSpace(prop.ContentLength), 10, 64);
+				err == nil && size >= 0 {
+				entry.Size = size
+			}
+			if t, conf := dates.Parse(prop.LastModified); conf != dates.Invalid {
+				entry.MTime = t.Unix()
+			}
+		}
+		if entry.IsDir {
+			entry.Size = -1
+			if !strings.HasSuffix(entry.Link.Path, "/") {
+				entry.Link.Path += "/"
+			}
+		}
+		entry.Name = path.Base(entry.Link.Path)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Status line of a propstat, e.g. "HTTP/1.1 200 OK"
+func davStatusOK(status string) bool {
+	fields := strings.Fields(status)
+	return len(fields) >= 2 && fields[1] == "200"
+}
@@ -0,0 +1,143 @@
+package crawler
+
+import (
+	"github.com/terorie/od-database-crawler/fasturl"
+	"golang.org/x/net/webdav"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// PROPFIND response of Apache mod_dav
+const apacheMultistatus = `<?xml version="1.0" encoding="utf-8"?>
+<D:multistatus xmlns:D="DAV:" xmlns:ns0="DAV:">
+<D:response xmlns:lp1="DAV:" xmlns:lp2="http://apache.org/dav/props/">
+<D:href>/dav/pub/</D:href>
+<D:propstat>
+<D:prop>
+<lp1:resourcetype><D:collection/></lp1:resourcetype>
+<lp1:getlastmodified>Sat, 01 Sep 2018 10:00:00 GMT</lp1:getlastmodified>
+</D:prop>
+<D:status>HTTP/1.1 200 OK</D:status>
+</D:propstat>
+<D:propstat>
+<D:prop>
+<getcontentlength xmlns="DAV:"/>
+</D:prop>
+<D:status>HTTP/1.1 404 Not Found</D:status>
+</D:propstat>
+</D:response>
+<D:response xmlns:lp1="DAV:" xmlns:lp2="http://apache.org/dav/props/">
+<D:href>/dav/pub/Some%20Album/</D:href>
+<D:propstat>
+<D:prop>
+<lp1:resourcetype><D:collection/></lp1:resourcetype>
+<lp1:getlastmodified>Sat, 01 Sep 2018 10:00:00 GMT</lp1:getlastmodified>
+</D:prop>
+<D:status>HTTP/1.1 200 OK</D:status>
+</D:propstat>
+</D:response>
+<D:response xmlns:lp1="DAV:" xmlns:lp2="http://apache.org/dav/props/">
+<D:href>http://example.org/dav/pub/track01.flac</D:href>
+<D:propstat>
+<D:prop>
+<lp1:resourcetype/>
+<lp1:getcontentlength>31457280</lp1:getcontentlength>
+<lp1:getlastmodified>Mon, 03 Sep 2018 12:30:00 GMT</lp1:getlastmodified>
+</D:prop>
+<D:status>HTTP/1.1 200 OK</D:status>
+</D:propstat>
+</D:response>
+<D:response>
+<D:href>/dav/other/escape.txt</D:href>
+<D:propstat>
+<D:prop><D:getcontentlength>1</D:getcontentlength></D:prop>
+<D:status>HTTP/1.1 200 OK</D:status>
+</D:propstat>
+</D:response>
+</D:multistatus>`
+
+func TestParseMultistatus(t *testing.T) {
+	var u fasturl.URL
+	if err := u.Parse("http://example.org/dav/pub/"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ParseMultistatus([]byte(apacheMultistatus), &u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkDirEntries(t, entries, []DirEntry {
+		{Name: "Some%20Album", IsDir: true, Size: -1,
+			MTime: 1535796000, Confidence: ConfidenceExact},
+		{Name: "track01.flac", Size: 31457280,
+			MTime: 1535977800, Confidence: ConfidenceExact},
+	})
+	if len(entries) == 2 && entries[0].Link.Path != "/dav/pub/Some%20Album/" {
+		t.Errorf("Unexpected link %s", entries[0].Link.Path)
+	}
+}
+
+func TestCrawlWebDAV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "od-webdav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mtime := time.Date(2018, 9, 1, 10, 0, 0, 0, time.UTC)
+	for name, size := range map[string]int{
+		"pub/a.bin":         100,
+		"pub/sub dir/b.bin": 200,
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(p, mtime, mtime)
+	}
+
+	var heads, propfinds int32
+	dav := &webdav.Handler{
+		FileSystem: webdav.Dir(dir),
+		LockSystem: webdav.NewMemLS(),
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "HEAD":
+			atomic.AddInt32(&heads, 1)
+		case "PROPFIND":
+			atomic.AddInt32(&propfinds, 1)
+		}
+		dav.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	result, files := crawlTestFiles(t, New(Options{JobBufferSize: -1}), Task{Url: s.URL + "/pub/"})
+	if result.StatusCode != "success" || result.ListingFormat != "webdav" {
+		t.Errorf("WebDAV not detected: %+v", result)
+	}
+	if heads != 0 || propfinds != 2 {
+		t.Errorf("Expected 2 PROPFINDs and no HEADs, got %d and %d", propfinds, heads)
+	}
+
+	var got []string
+	for _, f := range files {
+		got = append(got, fasturl.PathUnescape(f.Path + "/" + f.Name))
+		if f.MTime != mtime.Unix() {
+			t.Errorf("Wrong mtime for %s: %d", f.Name, f.MTime)
+		}
+	}
+	sort.Strings(got)
+	if len(got) != 2 || got[0] != "pub/a.bin" || got[1] != "pub/sub dir/b.bin" {
+		t.Errorf("Unexpected files %v", got)
+	}
+}
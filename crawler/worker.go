@@ -0,0 +1,345 @@
+package crawler
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/terorie/od-database-crawler/fasturl"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type WorkerContext struct {
+	OD *OD
+	Queue *BufferedQueue
+	FTP *FtpPool
+	// Held by workers while busy, checkpoints pause them
+	gate sync.RWMutex
+	Limiter HostLimiter
+	// Number of unfinished jobs
+	pending int64
+	// Jobs per second
+	rate rateMeter
+	// Busy workers, adapts to the site
+	conc concurrencyLimit
+	// Closed on resume, nil if not paused
+	resumeC chan struct{}
+	pauseM  sync.Mutex
+	// Set to drop all remaining jobs
+	stopped int32
+	// Running workers
+	workers sync.WaitGroup
+}
+
+// Worker crawls jobs of the queue until the crawl is over
+func (w *WorkerContext) Worker(results chan<- File) {
+	defer w.workers.Done()
+	ctx := w.OD.ctx
+	for {
+		if !w.waitResume() {
+			return
+		}
+		// Park without holding the gate, checkpoints
+		// must not miss a job taken out of the queue
+		if err := w.Queue.Wait(ctx); err != nil {
+			return
+		}
+		w.gate.RLock()
+		job, err := w.Queue.TryDequeue()
+		switch err {
+		case ErrQueueEmpty:
+			// Taken by another worker
+			w.gate.RUnlock()
+			continue
+
+		case ErrQueueClosed:
+			w.gate.RUnlock()
+			return
+
+		case nil:
+			if atomic.LoadInt32(&w.stopped) != StopNone {
+				// Task is stopping, drain the queue
+				w.finishJob()
+				w.gate.RUnlock()
+				continue
+			}
+			if wait := w.Limiter.Reserve(job.Uri.Host); wait > 0 {
+				// Host is rate limited, try again later
+				if err := w.Queue.Enqueue(&job); err != nil {
+					panic(err)
+				}
+				w.gate.RUnlock()
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			w.conc.acquire()
+			w.step(results, job)
+			w.conc.release()
+			w.gate.RUnlock()
+
+		default:
+			panic(err)
+		}
+	}
+}
+
+func (w *WorkerContext) step(results chan<- File, job Job) {
+	defer w.finishJob()
+
+	c := w.OD.crawler
+	var f File
+
+	start := time.Now()
+	newJobs, files, err := w.DoJob(&job, &f)
+	atomic.AddUint64(&c.stats.Started, 1)
+	w.rate.Add()
+	if err == ErrKnown || err == ErrDisallowed || err == errSkipped {
+		return
+	}
+	w.conc.observe(time.Since(start), err)
+
+	rateErr, rateLimited := err.(*RateLimitError)
+	if rateLimited {
+		atomic.AddUint64(&c.stats.RateLimits, 1)
+		job.RateLimitWait += w.Limiter.Backoff(job.Uri.Host, rateErr.RetryAfter)
+	} else if err == nil {
+		w.Limiter.Success(job.Uri.Host)
+	}
+
+	if err != nil {
+		if rateLimited && freeRateLimit(&job, rateErr) {
+			// Retry once the host lets us
+			job.RateLimits++
+			atomic.AddUint64(&c.stats.Retries, 1)
+			w.queueJob(job)
+			return
+		}
+		job.Fails++
+
+		if !shouldRetry(err) {
+			atomic.AddUint64(&c.stats.Aborted, 1)
+			atomic.AddUint64(&w.OD.Result.ErrorCount, 1)
+			if err == ErrRedirectLoop || err == ErrTooManyRedirects {
+				atomic.AddUint64(&c.stats.RedirectLoops, 1)
+			}
+			c.log.WithField("url", job.UriStr).
+				WithError(err).
+				Error("Giving up after failure")
+			w.giveUp(&job, err)
+			return
+		}
+
+		if job.Fails > c.opts.Retries {
+			atomic.AddUint64(&c.stats.Aborted, 1)
+			atomic.AddUint64(&w.OD.Result.ErrorCount, 1)
+			c.log.WithField("url", job.UriStr).
+				Errorf("Giving up after %d fails", job.Fails)
+			w.giveUp(&job, err)
+		} else {
+			atomic.AddUint64(&c.stats.Retries, 1)
+			w.queueJob(job)
+		}
+		return
+	}
+
+	atomic.AddUint64(&c.stats.Done, 1)
+	for _, job := range newJobs {
+		w.queueJob(job)
+	}
+
+	if !f.IsDir {
+		results <- f
+	}
+	for _, file := range files {
+		results <- file
+	}
+}
+
+func (w *WorkerContext) DoJob(job *Job, f *File) (newJobs []Job, files []File, err error) {
+	c := w.OD.crawler
+	if len(job.Uri.Path) == 0 { return }
+	robots, err := w.OD.Robots()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !w.OD.robotsAllowed(robots, &job.Uri) {
+		if job.Uri.Path == w.OD.BaseUri.Path {
+			w.OD.stopAt(StatusDisallowed)
+		}
+		return nil, nil, ErrDisallowed
+	}
+	if job.Uri.Path[len(job.Uri.Path)-1] == '/' {
+		// Load directory
+		var links []fasturl.URL
+		if job.Uri.Scheme == fasturl.SchemeFTP {
+			w.OD.Format.Detect(constFormat(FormatFTP))
+			links, files, err = GetDirFTP(w.FTP, job, f)
+		} else {
+			links, files, err = w.OD.listDir(job, f)
+		}
+		if err == ErrNotListing {
+			if job.Uri.Path == w.OD.BaseUri.Path {
+				w.OD.stopAt(StatusNotListing)
+			} else {
+				// Just a page linked from a listing
+				atomic.AddUint64(&w.OD.Result.SkippedNotListing, 1)
+				if c.opts.Verbose {
+					c.log.WithField("url", job.UriStr).
+						Debug("Skipping page: not a directory listing")
+				}
+				return nil, nil, errSkipped
+			}
+		}
+		if err != nil {
+			if !c.isErrSilent(err) {
+				c.log.WithError(err).
+					WithField("url", job.UriStr).
+					Error("Failed to crawl dir")
+			}
+			return nil, nil, err
+		}
+
+		// Hash directory
+		hash := f.HashDir(links, files)
+
+		// Skip symlinked dirs
+		if w.OD.LoadOrStoreKey(&hash) {
+			return nil, nil, ErrKnown
+		}
+		atomic.AddUint64(&w.OD.Result.FileCount, uint64(len(files)))
+		atomic.AddUint64(&w.OD.dirCount, 1)
+		w.OD.checkLimits()
+
+		// Sort by path
+		sort.Slice(links, func(i, j int) bool {
+			return strings.Compare(links[i].Path, links[j].Path) < 0
+		})
+
+		var newJobCount int
+		var lastLink string
+		for _, link := range links {
+			uriStr := link.String()
+
+			// Ignore dupes
+			if uriStr == lastLink {
+				continue
+			}
+			lastLink = uriStr
+
+			if reason := c.trapReason(&w.OD.BaseUri, &link); reason != "" {
+				atomic.AddUint64(&c.stats.Traps, 1)
+				if c.opts.Verbose {
+					c.log.WithField("url", uriStr).
+						Debugf("Skipping link: %s", reason)
+				}
+				continue
+			}
+			if !w.OD.robotsAllowed(robots, &link) {
+				continue
+			}
+
+			newJobs = append(newJobs, Job{
+				Uri:    link,
+				UriStr: uriStr,
+				Fails:  0,
+			})
+
+			newJobCount++
+		}
+		if c.opts.Verbose {
+			c.log.WithFields(logrus.Fields{
+				"url":   job.UriStr,
+				"files": newJobCount + len(files),
+			}).Debug("Listed")
+		}
+	} else {
+		// Load file
+		var final fasturl.URL
+		final, err = w.OD.GetFile(job.Uri, f)
+		if err != nil {
+			if !c.isErrSilent(err) {
+				c.log.WithError(err).
+					WithField("url", job.UriStr).
+					Error("Failed to crawl file")
+			}
+			return nil, nil, err
+		}
+		if strings.HasSuffix(final.Path, "/") {
+			// Redirected to a directory (missing slash)
+			if !inScope(&w.OD.BaseUri, &final) {
+				return nil, nil, ErrRedirectOutOfScope
+			}
+			f.IsDir = true
+			newJobs = append(newJobs, Job{
+				Uri:    final,
+				UriStr: final.String(),
+				Fails:  0,
+			})
+			return
+		}
+		atomic.AddUint64(&w.OD.Result.FileCount, 1)
+		w.OD.checkLimits()
+	}
+	return
+}
+
+// waitResume blocks while the task is paused.
+// Returns false if the crawl ended meanwhile.
+func (w *WorkerContext) waitResume() bool {
+	w.pauseM.Lock()
+	resumeC := w.resumeC
+	w.pauseM.Unlock()
+	if resumeC == nil {
+		return true
+	}
+	select {
+	case <-resumeC:
+		return true
+	case <-w.OD.ctx.Done():
+		return false
+	}
+}
+
+func (w *WorkerContext) queueJob(job Job) {
+	w.OD.Wait.Add(1)
+	atomic.AddInt64(&w.pending, 1)
+
+	if err := w.Queue.Enqueue(&job); err != nil {
+		panic(err)
+	}
+}
+
+func (w *WorkerContext) finishJob() {
+	atomic.AddInt64(&w.pending, -1)
+	w.OD.Wait.Done()
+}
+
+func (w *WorkerContext) giveUp(job *Job, err error) {
+	if onError := w.OD.crawler.opts.OnError; onError != nil {
+		onError(&w.OD.Task, job, err)
+	}
+}
+
+func (c *Crawler) isErrSilent(err error) bool {
+	if !c.opts.PrintHTTP {
+		if _, ok := err.(*HttpError); ok {
+			return true
+		}
+		if _, ok := err.(*textproto.Error); ok {
+			return true
+		}
+		if err == ErrRedirectOutOfScope {
+			return true
+		}
+		if _, ok := err.(*RateLimitError); ok {
+			return true
+		}
+	}
+	return false
+}
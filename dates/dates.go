@@ -0,0 +1,194 @@
+// Package dates parses the dates of HTTP headers and
+// directory listings. Unknown or broken dates never
+// panic, they are reported with confidence Invalid.
+package dates
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Confidence tells how exact a parsed date is
+type Confidence uint8
+const (
+	// Not a date
+	Invalid Confidence = iota
+	// Day and month might be swapped (e.g. 01/02/2018)
+	Ambiguous
+	// Only the day is known
+	Day
+	// Time to the minute, zone assumed UTC
+	Minute
+	// Time to the second, zone assumed UTC
+	Second
+	// Full timestamp with time zone
+	Exact
+)
+
+var confidenceNames = [...]string {
+	"invalid",
+	"ambiguous",
+	"day",
+	"minute",
+	"second",
+	"exact",
+}
+
+func (c Confidence) String() string {
+	if int(c) >= len(confidenceNames) {
+		return "invalid"
+	}
+	return confidenceNames[c]
+}
+
+// HTTP date forms (RFC 7231), in order of preference
+var HTTPLayouts = []string {
+	// IMF-fixdate
+	"Mon, 02 Jan 2006 15:04:05 MST",
+	// RFC 850
+	"Mon, 02-Jan-06 15:04:05 MST",
+	// asctime
+	"Mon Jan _2 15:04:05 2006",
+	// Seen in the wild
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 02 Jan 2006 15:04:05 -0700",
+	"Mon, 02-Jan-2006 15:04:05 MST",
+	"Mon, 02 Jan 2006 15:04:05",
+	"02 Jan 2006 15:04:05 MST",
+}
+
+// Layouts of directory listings. Month and weekday
+// names are normalized to English abbreviations
+// before parsing, so "Monday" and "January" are
+// written as "Mon" and "Jan".
+var ListingLayouts = []string {
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	// nginx, Apache
+	"02-Jan-2006 15:04:05",
+	"02-Jan-2006 15:04",
+	"02-Jan-2006",
+	// lighttpd
+	"2006-Jan-02 15:04:05",
+	"2006-Jan-02 15:04",
+	// IIS (US)
+	"1/2/2006 3:04 PM",
+	"1/2/2006 3:04:05 PM",
+	"Mon, Jan 2, 2006 3:04 PM",
+	"Mon, Jan 2, 2006",
+	// European
+	"2.1.2006 15:04:05",
+	"2.1.2006 15:04",
+	"2.1.2006",
+	"2/1/2006 15:04",
+	"02 Jan 2006 15:04",
+	"2 Jan 2006 15:04",
+	"Jan 2 2006 15:04",
+	"Jan 2, 2006 15:04",
+	"2006/01/02 15:04:05",
+	"2006/01/02 15:04",
+	"2006.01.02 15:04",
+}
+
+// Layouts tried by Parse
+var Layouts = append(append([]string{}, HTTPLayouts...), ListingLayouts...)
+
+// Earliest and latest plausible dates
+var (
+	minDate = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+	maxDate = time.Date(2200, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// Parse parses a date in any known form.
+func Parse(v string) (time.Time, Confidence) {
+	return ParseLayouts(v, Layouts)
+}
+
+// ParseLayouts parses a date with the first matching layout.
+// Month and weekday names in English, German, French, Spanish,
+// Italian, Dutch and Portuguese are understood. Dates without
+// a zone are in UTC.
+func ParseLayouts(v string, layouts []string) (time.Time, Confidence) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, Invalid
+	}
+	v = normalize(v)
+
+	for _, layout := range layouts {
+		t, err := time.Parse(layout, v)
+		if err != nil {
+			continue
+		}
+		if t.Before(minDate) || t.After(maxDate) {
+			continue
+		}
+		return t, layoutConfidence(layout, t)
+	}
+	return time.Time{}, Invalid
+}
+
+func layoutConfidence(layout string, t time.Time) Confidence {
+	if strings.HasPrefix(layout, "1/2/") || strings.HasPrefix(layout, "2/1/") {
+		// Numeric dates with slashes differ by locale
+		if t.Day() <= 12 && t.Day() != int(t.Month()) {
+			return Ambiguous
+		}
+	}
+	switch {
+	case !strings.Contains(layout, "04"):
+		return Day
+	case !strings.Contains(layout, "05"):
+		return Minute
+	case strings.Contains(layout, "MST") ||
+		strings.Contains(layout, "-0700") ||
+		strings.Contains(layout, "Z07"):
+		return Exact
+	default:
+		return Second
+	}
+}
+
+// normalize replaces month and weekday names
+// with English abbreviations.
+func normalize(v string) string {
+	var b strings.Builder
+	b.Grow(len(v))
+	word := -1
+	skipDot := false
+	flush := func(end int) {
+		if word < 0 {
+			return
+		}
+		w := v[word:end]
+		if abbr, ok := names[strings.ToLower(w)]; ok {
+			b.WriteString(abbr)
+			// "janv.", "Okt."
+			skipDot = true
+		} else {
+			b.WriteString(w)
+		}
+		word = -1
+	}
+	for i, r := range v {
+		if unicode.IsLetter(r) {
+			if word < 0 {
+				word = i
+			}
+			continue
+		}
+		flush(i)
+		if skipDot && r == '.' {
+			skipDot = false
+			continue
+		}
+		skipDot = false
+		b.WriteRune(r)
+	}
+	flush(len(v))
+	return b.String()
+}
@@ -0,0 +1,81 @@
+package dates
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	utc := func(year int, month time.Month, day, hour, min, sec int) int64 {
+		return time.Date(year, month, day, hour, min, sec, 0, time.UTC).Unix()
+	}
+
+	tests := []struct{
+		in   string
+		unix int64
+		conf Confidence
+	}{
+		// HTTP
+		{"Sun, 06 Nov 1994 08:49:37 GMT", utc(1994, 11, 6, 8, 49, 37), Exact},
+		{"Sunday, 06-Nov-94 08:49:37 GMT", utc(1994, 11, 6, 8, 49, 37), Exact},
+		{"Sun Nov  6 08:49:37 1994", utc(1994, 11, 6, 8, 49, 37), Second},
+		{"Sun, 6 Nov 1994 08:49:37 GMT", utc(1994, 11, 6, 8, 49, 37), Exact},
+		{"Sun, 06 Nov 1994 10:49:37 +0200", utc(1994, 11, 6, 8, 49, 37), Exact},
+		{"Sun, 06 Nov 1994 08:49:37", utc(1994, 11, 6, 8, 49, 37), Second},
+		{"  Sun, 06 Nov 1994 08:49:37 GMT  ", utc(1994, 11, 6, 8, 49, 37), Exact},
+		{"2018-09-01T10:00:00.000Z", utc(2018, 9, 1, 10, 0, 0), Exact},
+		{"2018-09-01T12:00:00+02:00", utc(2018, 9, 1, 10, 0, 0), Exact},
+		// Listings
+		{"2018-09-01 10:00:05", utc(2018, 9, 1, 10, 0, 5), Second},
+		{"2018-09-01 10:00", utc(2018, 9, 1, 10, 0, 0), Minute},
+		{"2018-09-01", utc(2018, 9, 1, 0, 0, 0), Day},
+		{"04-Sep-2018 13:31", utc(2018, 9, 4, 13, 31, 0), Minute},
+		{"04-SEP-2018 13:31", utc(2018, 9, 4, 13, 31, 0), Minute},
+		{"2018-Sep-04 13:31:05", utc(2018, 9, 4, 13, 31, 5), Second},
+		{"1/16/2018 2:00 PM", utc(2018, 1, 16, 14, 0, 0), Minute},
+		{"Tuesday, January 16, 2018 2:00 PM", utc(2018, 1, 16, 14, 0, 0), Minute},
+		{"2/1/2018 2:00 PM", utc(2018, 2, 1, 14, 0, 0), Ambiguous},
+		{"16.01.2018 14:00", utc(2018, 1, 16, 14, 0, 0), Minute},
+		{"16 Jan 2018 14:00", utc(2018, 1, 16, 14, 0, 0), Minute},
+		{"2018/01/16 14:00", utc(2018, 1, 16, 14, 0, 0), Minute},
+		// Localized
+		{"04-Okt-2018 13:31", utc(2018, 10, 4, 13, 31, 0), Minute},
+		{"04-Mär-2018 13:31", utc(2018, 3, 4, 13, 31, 0), Minute},
+		{"04 janv. 2018 13:31", utc(2018, 1, 4, 13, 31, 0), Minute},
+		{"04 décembre 2018 13:31", utc(2018, 12, 4, 13, 31, 0), Minute},
+		{"04-dic-2018 13:31", utc(2018, 12, 4, 13, 31, 0), Minute},
+		{"04 mei 2018 13:31", utc(2018, 5, 4, 13, 31, 0), Minute},
+		{"Montag, Oktober 1, 2018", utc(2018, 10, 1, 0, 0, 0), Day},
+		// Invalid
+		{"", 0, Invalid},
+		{"2018", 0, Invalid},
+		{"-", 0, Invalid},
+		{"31-Feb-2018 10:00", 0, Invalid},
+		{"1969-12-31", 0, Invalid},
+		{"Sun, 06 Nov", 0, Invalid},
+		{"ä", 0, Invalid},
+	}
+
+	for _, test := range tests {
+		got, conf := Parse(test.in)
+		if conf != test.conf {
+			t.Errorf(`"%s": expected confidence %s, got %s`, test.in, test.conf, conf)
+			continue
+		}
+		if conf != Invalid && got.Unix() != test.unix {
+			t.Errorf(`"%s": expected %s, got %s`, test.in,
+				time.Unix(test.unix, 0).UTC(), got.UTC())
+		}
+	}
+}
+
+func TestParseLayouts(t *testing.T) {
+	// European day first
+	got, conf := ParseLayouts("02/01/2018 10:00", []string{"2/1/2006 15:04"})
+	if conf != Ambiguous || got.Month() != time.January || got.Day() != 2 {
+		t.Errorf("Unexpected %s (%s)", got, conf)
+	}
+	if _, conf := ParseLayouts("2018-01-16", []string{"02-Jan-2006"}); conf != Invalid {
+		t.Errorf("Parsed with wrong layout (%s)", conf)
+	}
+}
@@ -0,0 +1,53 @@
+package dates
+
+// Month and weekday names (lower case) and
+// their English abbreviation used by layouts
+var names = make(map[string]string)
+
+var monthNames = [12][]string {
+	{"jan", "january", "januar", "janvier", "janv", "enero", "ene", "gennaio", "gen", "januari", "janeiro"},
+	{"feb", "february", "februar", "février", "fevrier", "févr", "fevr", "febrero", "febbraio", "februari", "fevereiro", "fev"},
+	{"mar", "march", "märz", "maerz", "mär", "mars", "marzo", "maart", "mrt", "março", "marco"},
+	{"apr", "april", "avril", "avr", "abril", "abr", "aprile"},
+	{"may", "mai", "mayo", "maggio", "mag", "mei", "maio"},
+	{"jun", "june", "juni", "juin", "junio", "giugno", "giu", "junho"},
+	{"jul", "july", "juli", "juillet", "juil", "julio", "luglio", "lug", "julho"},
+	{"aug", "august", "août", "aout", "agosto", "ago", "augustus"},
+	{"sep", "sept", "september", "septembre", "septiembre", "set", "settembre", "setembro"},
+	{"oct", "october", "oktober", "okt", "octobre", "octubre", "ottobre", "ott", "outubro", "out"},
+	{"nov", "november", "novembre", "noviembre", "novembro"},
+	{"dec", "december", "dezember", "dez", "décembre", "decembre", "déc", "diciembre", "dic", "dicembre", "dezembro"},
+}
+
+var weekdayNames = [7][]string {
+	{"sun", "sunday", "sonntag", "dimanche", "dim", "domingo", "dom", "domenica", "zondag"},
+	{"mon", "monday", "montag", "lundi", "lun", "lunes", "lunedì", "lunedi", "maandag", "segunda"},
+	{"tue", "tuesday", "dienstag", "mardi", "mar", "martes", "martedì", "martedi", "dinsdag", "terça", "terca"},
+	{"wed", "wednesday", "mittwoch", "mercredi", "mer", "miércoles", "miercoles", "mié", "mercoledì", "mercoledi", "woensdag", "quarta"},
+	{"thu", "thursday", "donnerstag", "jeudi", "jeu", "jueves", "jue", "giovedì", "giovedi", "gio", "donderdag", "quinta"},
+	{"fri", "friday", "freitag", "vendredi", "ven", "viernes", "vie", "venerdì", "venerdi", "vrijdag", "sexta"},
+	{"sat", "saturday", "samstag", "samedi", "sam", "sábado", "sabado", "sáb", "sab", "sabato", "zaterdag"},
+}
+
+var monthAbbrs = [12]string {
+	"Jan", "Feb", "Mar", "Apr", "May", "Jun",
+	"Jul", "Aug", "Sep", "Oct", "Nov", "Dec",
+}
+
+var weekdayAbbrs = [7]string {
+	"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat",
+}
+
+func init() {
+	for i, list := range weekdayNames {
+		for _, name := range list {
+			names[name] = weekdayAbbrs[i]
+		}
+	}
+	// Months win over weekdays ("mar")
+	for i, list := range monthNames {
+		for _, name := range list {
+			names[name] = monthAbbrs[i]
+		}
+	}
+}
@@ -223,6 +223,22 @@ func (tree *Tree) Clear() {
 	tree.size = 0
 }
 
+// Keys returns all keys of the tree in-order.
+func (tree *Tree) Keys() []Key {
+	keys := make([]Key, 0, tree.size)
+	keys = appendKeys(keys, tree.Root)
+	return keys
+}
+
+func appendKeys(keys []Key, node *Node) []Key {
+	if node == nil {
+		return keys
+	}
+	keys = appendKeys(keys, node.Left)
+	keys = append(keys, node.Key)
+	return appendKeys(keys, node.Right)
+}
+
 // String returns a string representation of container
 func (tree *Tree) String() string {
 	str := "RedBlackTree\n"
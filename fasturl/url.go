@@ -24,6 +24,7 @@ const (
 	SchemeInvalid = iota
 	SchemeHTTP
 	SchemeHTTPS
+	SchemeFTP
 	SchemeCount
 )
 
@@ -31,6 +32,7 @@ var Schemes = [SchemeCount]string {
 	"",
 	"http",
 	"https",
+	"ftp",
 }
 
 var ErrUnknownScheme = errors.New("unknown protocol scheme")
@@ -354,6 +356,8 @@ func getscheme(rawurl string) (scheme Scheme, path string, err error) {
 				scheme = SchemeHTTP
 			case "https":
 				scheme = SchemeHTTPS
+			case "ftp":
+				scheme = SchemeFTP
 			default:
 				return SchemeInvalid, "", ErrUnknownScheme
 			}
@@ -1,7 +1,7 @@
 package main
 
 const helpText =
-`HTTP crawler for the OD-Database
+`HTTP/FTP crawler for the OD-Database
   DB      >> https://od-db.the-eye.eu <<
   Crawler >> https://github.com/terorie/od-database-crawler <<
   Server  >> https://github.com/simon987/od-database <<
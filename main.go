@@ -6,6 +6,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/terorie/od-database-crawler/crawler"
 	"github.com/terorie/od-database-crawler/fasturl"
 	"os"
 	"os/signal"
@@ -62,6 +63,12 @@ func preRun(cmd *cobra.Command, args []string) error {
 	if err := os.MkdirAll("queue", 0755);
 		err != nil { panic(err) }
 
+	if err := os.MkdirAll("checkpoint", 0755);
+		err != nil { panic(err) }
+
+	if err := os.MkdirAll("outbox", 0755);
+		err != nil { panic(err) }
+
 	return nil
 }
 
@@ -76,15 +83,28 @@ func main() {
 func cmdBase(_ *cobra.Command, _ []string) {
 	onlineMode = true
 	readConfig()
+	ListenLocal()
 
 	appCtx, soft := context.WithCancel(context.Background())
 	forceCtx, hard := context.WithCancel(context.Background())
 	go hardShutdown(forceCtx)
 	go listenCtrlC(soft, hard)
 
-	inRemotes := make(chan *OD)
+	inRemotes := make(chan *Remote)
 	go Schedule(appCtx, inRemotes)
 
+	// Upload results of finished tasks
+	go Outbox(appCtx)
+
+	// Resume unfinished tasks
+	for _, cp := range ListCheckpoints() {
+		if cp.Task.WebsiteId == 0 {
+			// Saved by the crawl command
+			continue
+		}
+		ScheduleCheckpoint(inRemotes, cp)
+	}
+
 	ticker := time.NewTicker(config.Recheck)
 	defer ticker.Stop()
 	for {
@@ -112,9 +132,8 @@ func cmdBase(_ *cobra.Command, _ []string) {
 			var baseUri fasturl.URL
 			err = baseUri.Parse(t.Url)
 			if urlErr, ok := err.(*fasturl.Error); ok && urlErr.Err == fasturl.ErrUnknownScheme {
-				// Not an error
+				// Not an error, protocol not supported
 				err = nil
-				// TODO FTP crawler
 				continue
 			} else if err != nil {
 				logrus.WithError(err).
@@ -127,12 +146,16 @@ func cmdBase(_ *cobra.Command, _ []string) {
 	}
 
 shutdown:
+	if config.Checkpoint > 0 {
+		SuspendTasks()
+	}
 	globalWait.Wait()
 }
 
 func cmdCrawler(_ *cobra.Command, args []string) error {
 	onlineMode = false
 	readConfig()
+	ListenLocal()
 
 	arg := args[0]
 	// https://github.com/golang/go/issues/19779
@@ -146,23 +169,43 @@ func cmdCrawler(_ *cobra.Command, args []string) error {
 	}
 	if err != nil { return err }
 
-	// TODO Graceful shutdown
-	forceCtx := context.Background()
-
-	inRemotes := make(chan *OD)
-	go Schedule(forceCtx, inRemotes)
+	appCtx, soft := context.WithCancel(context.Background())
+	forceCtx, hard := context.WithCancel(context.Background())
+	go hardShutdown(forceCtx)
+	go listenCtrlC(soft, hard)
 
-	ticker := time.NewTicker(3 * time.Second)
-	defer ticker.Stop()
+	inRemotes := make(chan *Remote)
+	go Schedule(appCtx, inRemotes)
 
-	task := Task {
+	task := crawler.Task {
 		WebsiteId: 0,
-		Url: u.String(),
+		// Keep credentials (fasturl drops them)
+		Url: arg,
+	}
+
+	// Resume previous crawl of the same URL
+	cp, err := LoadCheckpoint(task.WebsiteId)
+	if err == nil && cp.Task.Url == task.Url {
+		ScheduleCheckpoint(inRemotes, cp)
+	} else {
+		RemoveCheckpoint(task.WebsiteId)
+		ScheduleTask(inRemotes, &task, &u)
 	}
-	ScheduleTask(inRemotes, &task, &u)
 
 	// Wait for all jobs to finish
-	globalWait.Wait()
+	done := make(chan struct{})
+	go func() {
+		globalWait.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-appCtx.Done():
+		if config.Checkpoint > 0 {
+			SuspendTasks()
+		}
+		<-done
+	}
 
 	return nil
 }
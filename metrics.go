@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"github.com/terorie/od-database-crawler/crawler"
+	"github.com/valyala/fasthttp"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Metrics in the Prometheus text exposition format
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+
+var requestBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+var (
+	metricRequests = newMetricVec("od_crawler_requests_total", "counter",
+		"Requests sent to sites by method and status or error class.",
+		"method", "class")
+	metricBytes = newMetricVec("od_crawler_received_bytes_total", "counter",
+		"Bytes received from sites.")
+	metricUploads = newMetricVec("od_crawler_upload_chunks_total", "counter",
+		"Result chunks uploaded to the server.",
+		"result")
+	metricLatency = newHistogramVec("od_crawler_request_duration_seconds",
+		"Request latency by method.", requestBuckets,
+		"method")
+)
+
+// metricVec is a counter or gauge with labels
+type metricVec struct {
+	name   string
+	typ    string
+	help   string
+	labels []string
+	m      sync.Mutex
+	values map[string]float64
+}
+
+func newMetricVec(name, typ, help string, labels ...string) *metricVec {
+	return &metricVec{
+		name:   name,
+		typ:    typ,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+}
+
+func (v *metricVec) Add(delta float64, labelValues ...string) {
+	key := formatLabels(v.labels, labelValues)
+	v.m.Lock()
+	v.values[key] += delta
+	v.m.Unlock()
+}
+
+func (v *metricVec) Write(w io.Writer) {
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	writeHeader(w, v.name, v.typ, v.help)
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writeSample(w, v.name, key, v.values[key])
+	}
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// histogramVec is a histogram with labels
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	m       sync.Mutex
+	values  map[string]*histogram
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		values:  make(map[string]*histogram),
+	}
+}
+
+func (v *histogramVec) Observe(value float64, labelValues ...string) {
+	key := formatLabels(v.labels, labelValues)
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	h := v.values[key]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(v.buckets))}
+		v.values[key] = h
+	}
+	for i, bound := range v.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (v *histogramVec) Write(w io.Writer) {
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	writeHeader(w, v.name, "histogram", v.help)
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		h := v.values[key]
+		for i, bound := range v.buckets {
+			le := "le=\"" + strconv.FormatFloat(bound, 'g', -1, 64) + "\""
+			writeSample(w, v.name + "_bucket", joinLabels(key, le), float64(h.counts[i]))
+		}
+		writeSample(w, v.name + "_bucket", joinLabels(key, `le="+Inf"`), float64(h.count))
+		writeSample(w, v.name + "_sum", key, h.sum)
+		writeSample(w, v.name + "_count", key, float64(h.count))
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names []string, values []string) string {
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString("=\"")
+		b.WriteString(labelEscaper.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+func joinLabels(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeSample(w io.Writer, name, labels string, value float64) {
+	if labels != "" {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, labels,
+			strconv.FormatFloat(value, 'g', -1, 64))
+	} else {
+		fmt.Fprintf(w, "%s %s\n", name,
+			strconv.FormatFloat(value, 'g', -1, 64))
+	}
+}
+
+// observeRequest records a request to a site
+func observeRequest(r *crawler.RequestInfo) {
+	metricRequests.Add(1, r.Method, requestClass(r.Err, r.Status))
+	metricLatency.Observe(r.Duration.Seconds(), r.Method)
+	if r.Received > 0 {
+		metricBytes.Add(float64(r.Received))
+	}
+}
+
+func requestClass(err error, status int) string {
+	if err == nil {
+		return fmt.Sprintf("%dxx", status / 100)
+	}
+	if err == fasthttp.ErrTimeout {
+		return "timeout"
+	}
+	switch err := err.(type) {
+	case *textproto.Error:
+		return fmt.Sprintf("%dxx", err.Code / 100)
+	case *net.DNSError:
+		return "dns"
+	case net.Error:
+		if err.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+	return "error"
+}
+
+func WriteMetrics(w io.Writer) {
+	metricRequests.Write(w)
+	metricLatency.Write(w)
+	metricBytes.Write(w)
+	metricUploads.Write(w)
+
+	jobs := newMetricVec("od_crawler_jobs_total", "counter",
+		"Crawl jobs by result.", "result")
+	stats := engine.Stats()
+	jobs.Add(float64(stats.Started), "started")
+	jobs.Add(float64(stats.Done), "done")
+	jobs.Add(float64(stats.Retries), "retried")
+	jobs.Add(float64(stats.Aborted), "aborted")
+	jobs.Write(w)
+
+	active := newMetricVec("od_crawler_active_tasks", "gauge",
+		"Tasks being crawled.")
+	files := newMetricVec("od_crawler_task_files", "gauge",
+		"Files found per task.", "website_id")
+	queued := newMetricVec("od_crawler_task_queue_length", "gauge",
+		"Pending jobs per task.", "website_id")
+	workers := newMetricVec("od_crawler_task_workers", "gauge",
+		"Connections per task.", "website_id")
+
+	active.Add(float64(atomic.LoadInt32(&numActiveTasks)))
+	activeTasksLock.Lock()
+	for id, od := range activeTasks {
+		websiteId := strconv.FormatUint(id, 10)
+		files.Add(float64(atomic.LoadUint64(&od.Result.FileCount)), websiteId)
+		queued.Add(float64(od.Pending()), websiteId)
+		workers.Add(float64(od.Workers()), websiteId)
+	}
+	activeTasksLock.Unlock()
+
+	active.Write(w)
+	files.Write(w)
+	queued.Write(w)
+	workers.Write(w)
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	WriteMetrics(w)
+}
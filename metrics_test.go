@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMetricsFormat(t *testing.T) {
+	counter := newMetricVec("test_requests_total", "counter",
+		"Test counter.", "method", "class")
+	counter.Add(1, "GET", "2xx")
+	counter.Add(2, "GET", "2xx")
+	counter.Add(1, "HEAD", `a"b`)
+
+	hist := newHistogramVec("test_duration_seconds", "Test histogram.",
+		[]float64{.1, 1}, "method")
+	hist.Observe(.05, "GET")
+	hist.Observe(.5, "GET")
+	hist.Observe(5, "GET")
+
+	var buf bytes.Buffer
+	counter.Write(&buf)
+	hist.Write(&buf)
+
+	expected := `# HELP test_requests_total Test counter.
+# TYPE test_requests_total counter
+test_requests_total{method="GET",class="2xx"} 3
+test_requests_total{method="HEAD",class="a\"b"} 1
+# HELP test_duration_seconds Test histogram.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{method="GET",le="0.1"} 1
+test_duration_seconds_bucket{method="GET",le="1"} 2
+test_duration_seconds_bucket{method="GET",le="+Inf"} 3
+test_duration_seconds_sum{method="GET"} 5.55
+test_duration_seconds_count{method="GET"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	WriteMetrics(&buf)
+	for _, name := range []string{
+		"od_crawler_requests_total",
+		"od_crawler_request_duration_seconds",
+		"od_crawler_active_tasks",
+		"od_crawler_task_queue_length",
+		"od_crawler_task_workers",
+	} {
+		if !strings.Contains(buf.String(), "# TYPE " + name + " ") {
+			t.Errorf("Missing metric %s", name)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/terorie/od-database-crawler/crawler"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Finished crawls wait in the outbox until the
+// OD-DB server accepted both the file list and
+// the task result. Survives restarts.
+
+const outboxMaxDelay = time.Hour
+
+// OutboxEntry is saved as outbox/<id>.task.json,
+// the file list is outbox/<id>.json.
+type OutboxEntry struct {
+	Result    crawler.TaskResult `json:"result"`
+	// Bytes of the file list already uploaded
+	Uploaded  int64              `json:"uploaded"`
+	Attempts  int                `json:"attempts"`
+	NextRetry time.Time          `json:"next_retry"`
+}
+
+var outboxWake = make(chan struct{}, 1)
+
+func outboxPaths(websiteId uint64) (entryPath, listPath string) {
+	entryPath = path.Join("outbox", fmt.Sprintf("%d.task.json", websiteId))
+	listPath = path.Join("outbox", fmt.Sprintf("%d.json", websiteId))
+	return
+}
+
+// SpoolResult moves the results of a finished
+// crawl to the outbox and wakes up the uploader.
+func SpoolResult(result *crawler.TaskResult, resultsPath string) error {
+	entry := OutboxEntry{Result: *result}
+	entryPath, listPath := outboxPaths(result.WebsiteId)
+	if err := entry.save(entryPath); err != nil {
+		return err
+	}
+	if err := os.Rename(resultsPath, listPath); err != nil {
+		os.Remove(entryPath)
+		return err
+	}
+
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (e *OutboxEntry) save(entryPath string) error {
+	buf, err := json.Marshal(e)
+	if err != nil { panic(err) }
+
+	// Replace atomically
+	tmpPath := entryPath + ".tmp"
+	err = ioutil.WriteFile(tmpPath, buf, 0644)
+	if err != nil { return err }
+	return os.Rename(tmpPath, entryPath)
+}
+
+func loadOutbox() (entries []*OutboxEntry) {
+	matches, _ := filepath.Glob(path.Join("outbox", "*.task.json"))
+	for _, match := range matches {
+		buf, err := ioutil.ReadFile(match)
+		if err != nil {
+			logrus.WithError(err).
+				WithField("file", match).
+				Error("Failed to read outbox")
+			continue
+		}
+		entry := new(OutboxEntry)
+		if err := json.Unmarshal(buf, entry); err != nil {
+			logrus.WithError(err).
+				WithField("file", match).
+				Error("Corrupt outbox entry")
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].NextRetry.Before(entries[j].NextRetry)
+	})
+	return
+}
+
+// Outbox uploads spooled results until the context is done.
+func Outbox(c context.Context) {
+	for {
+		wait := flushOutbox(c)
+		select {
+		case <-c.Done():
+			return
+		case <-outboxWake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// flushOutbox uploads all entries that are due.
+// Returns the time until the next retry.
+func flushOutbox(c context.Context) time.Duration {
+	wait := outboxMaxDelay
+	for _, entry := range loadOutbox() {
+		if c.Err() != nil {
+			return 0
+		}
+		if d := time.Until(entry.NextRetry); d > 0 {
+			if d < wait {
+				wait = d
+			}
+			continue
+		}
+
+		err := entry.push()
+		if err == nil {
+			continue
+		}
+
+		entry.Attempts++
+		delay := viper.GetDuration(ConfUploadRetryInterval) << uint(entry.Attempts - 1)
+		if delay > outboxMaxDelay || delay <= 0 {
+			delay = outboxMaxDelay
+		}
+		entry.NextRetry = time.Now().Add(delay)
+		if delay < wait {
+			wait = delay
+		}
+
+		logrus.WithError(err).
+			WithField("id", entry.Result.WebsiteId).
+			WithField("retry_in", delay).
+			Error("Failed uploading crawl results")
+
+		entryPath, _ := outboxPaths(entry.Result.WebsiteId)
+		if err := entry.save(entryPath); err != nil {
+			logrus.WithError(err).
+				Error("Failed to update outbox")
+		}
+	}
+	return wait
+}
+
+// push uploads the entry and removes it on success.
+func (e *OutboxEntry) push() error {
+	entryPath, listPath := outboxPaths(e.Result.WebsiteId)
+	f, err := os.Open(listPath)
+	if os.IsNotExist(err) {
+		logrus.WithField("id", e.Result.WebsiteId).
+			Error("File list of spooled result is missing")
+		os.Remove(entryPath)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = PushResult(&e.Result, f, e.Uploaded, func(offset int64) {
+		// Don't upload chunks twice after a failure
+		e.Uploaded = offset
+		if err := e.save(entryPath); err != nil {
+			logrus.WithError(err).
+				Error("Failed to update outbox")
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.WithField("id", e.Result.WebsiteId).
+		Info("Uploaded crawl results")
+
+	os.Remove(listPath)
+	os.Remove(entryPath)
+	return nil
+}
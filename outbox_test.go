@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"github.com/spf13/viper"
+	"github.com/terorie/od-database-crawler/crawler"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "od-outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir("outbox", 0755)
+
+	// Server fails the second chunk and the
+	// first completion, then recovers.
+	var m sync.Mutex
+	var chunks []string
+	var completed int
+	uploads, completes := 0, 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		defer m.Unlock()
+		switch r.URL.Path {
+		case "/task/upload":
+			uploads++
+			if uploads == 2 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			file, _, err := r.FormFile("file_list")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			chunk, _ := ioutil.ReadAll(file)
+			chunks = append(chunks, string(chunk))
+		case "/task/complete":
+			completes++
+			if completes == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			if !strings.Contains(r.FormValue("result"), `"website_id":7`) {
+				t.Errorf("Unexpected result %s", r.FormValue("result"))
+			}
+			completed++
+		}
+	}))
+	defer s.Close()
+
+	defer func(url string, chunkSize int64) {
+		config.ServerUrl = url
+		config.ChunkSize = chunkSize
+	}(config.ServerUrl, config.ChunkSize)
+	config.ServerUrl = s.URL
+	config.ChunkSize = 10
+	viper.Set(ConfUploadRetries, 1)
+	viper.Set(ConfUploadRetryInterval, time.Millisecond)
+
+	const list = "aaaaaaaa\nbbbbbbbb\ncccccccc\n"
+	ioutil.WriteFile("results.json", []byte(list), 0644)
+	err = SpoolResult(&crawler.TaskResult{WebsiteId: 7, StatusCode: "success"}, "results.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat("results.json"); !os.IsNotExist(err) {
+		t.Error("Results not moved to outbox")
+	}
+
+	c := context.Background()
+	for i := 0; i < 3; i++ {
+		flushOutbox(c)
+		entries := loadOutbox()
+		if len(entries) == 0 {
+			break
+		}
+		// Skip backoff
+		entries[0].NextRetry = time.Time{}
+		entryPath, _ := outboxPaths(7)
+		entries[0].save(entryPath)
+	}
+
+	if completed != 1 {
+		t.Fatalf("Expected task to be completed once, got %d", completed)
+	}
+	if strings.Join(chunks, "") != list {
+		t.Errorf("Chunks uploaded twice or lost: %q", chunks)
+	}
+	if entries := loadOutbox(); len(entries) != 0 {
+		t.Errorf("Outbox not empty: %+v", entries)
+	}
+	if _, err := os.Stat("outbox/7.json"); !os.IsNotExist(err) {
+		t.Error("File list not removed from outbox")
+	}
+}
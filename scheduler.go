@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"github.com/terorie/od-database-crawler/crawler"
 	"github.com/terorie/od-database-crawler/fasturl"
+	"io"
 	"os"
 	"path"
 	"sync"
@@ -14,11 +16,26 @@ import (
 )
 
 var activeTasksLock sync.Mutex
-var activeTasks = make(map[uint64]bool)
+var activeTasks = make(map[uint64]*Remote)
 var numActiveTasks int32
-var totalQueued int64
 
-func Schedule(c context.Context, remotes <-chan *OD) {
+// Crawler shared by all tasks, set up by readConfig
+var engine = crawler.New(crawler.Options{})
+
+var globalWait sync.WaitGroup
+
+// Remote is a task of the OD-DB server being crawled
+type Remote struct {
+	*crawler.OD
+
+	// Checkpoint to resume from
+	resume *Checkpoint
+	syncC  chan chan int64
+	checkpointStop chan struct{}
+	checkpointDone chan struct{}
+}
+
+func Schedule(c context.Context, remotes <-chan *Remote) {
 	go Stats(c)
 
 	for remote := range remotes {
@@ -26,38 +43,18 @@ func Schedule(c context.Context, remotes <-chan *OD) {
 			Info("Starting crawler")
 
 		// Collect results
-		results := make(chan File)
-
-		remote.WCtx.OD = remote
+		results := make(chan crawler.File)
 
-		// Get queue path
-		queuePath := path.Join("queue", fmt.Sprintf("%d", remote.Task.WebsiteId))
-
-		// Delete existing queue
-		if err := os.RemoveAll(queuePath);
-			err != nil { panic(err) }
-
-		// Start new queue
-		var err error
-		remote.WCtx.Queue, err = OpenQueue(queuePath)
+		err := remote.Start(context.Background(), results)
 		if err != nil { panic(err) }
-
-		// Spawn workers
-		for i := 0; i < config.Workers; i++ {
-			go remote.WCtx.Worker(results)
-		}
-
-		// Enqueue initial job
 		atomic.AddInt32(&numActiveTasks, 1)
-		remote.WCtx.queueJob(Job{
-			Uri:    remote.BaseUri,
-			UriStr: remote.BaseUri.String(),
-			Fails:  0,
-		})
 
 		// Upload result when ready
 		go remote.Watch(results)
 
+		// Save progress periodically
+		go remote.checkpointLoop()
+
 		// Sleep if max number of tasks are active
 		for atomic.LoadInt32(&numActiveTasks) > config.Tasks {
 			select {
@@ -70,102 +67,148 @@ func Schedule(c context.Context, remotes <-chan *OD) {
 	}
 }
 
-func ScheduleTask(remotes chan<- *OD, t *Task, u *fasturl.URL) {
-	if !t.register() {
+func ScheduleTask(remotes chan<- *Remote, t *crawler.Task, u *fasturl.URL) {
+	remote := newRemote(t, u)
+	if !remote.register() {
 		return
 	}
 
 	globalWait.Add(1)
-	now := time.Now()
-	od := &OD {
-		Task: *t,
-		BaseUri: *u,
-		Result: TaskResult {
-			WebsiteId: t.WebsiteId,
-			StartTime: now,
-			StartTimeUnix: now.Unix(),
-		},
-	}
-	remotes <- od
+	remotes <- remote
 }
 
-func (t *Task) register() bool {
+func newRemote(t *crawler.Task, u *fasturl.URL) *Remote {
+	return &Remote {
+		OD: engine.NewOD(t, u),
+		syncC: make(chan chan int64),
+		checkpointStop: make(chan struct{}),
+		checkpointDone: make(chan struct{}),
+	}
+}
+
+func (o *Remote) register() bool {
 	activeTasksLock.Lock()
 	defer activeTasksLock.Unlock()
 
-	if _, known := activeTasks[t.WebsiteId]; known {
+	if _, known := activeTasks[o.Task.WebsiteId]; known {
 		return false
 	} else {
-		activeTasks[t.WebsiteId] = true
+		activeTasks[o.Task.WebsiteId] = o
 		return true
 	}
 }
 
-func (t *Task) unregister() {
+func (o *Remote) unregister() {
 	activeTasksLock.Lock()
-	delete(activeTasks, t.WebsiteId)
+	delete(activeTasks, o.Task.WebsiteId)
 	activeTasksLock.Unlock()
 }
 
-func (o *OD) Watch(results chan File) {
+func (o *Remote) Watch(results chan crawler.File) {
 	// Mark job as completely done
 	defer globalWait.Done()
-	defer o.Task.unregister()
+	defer o.unregister()
 
 	filePath := path.Join("crawled", fmt.Sprintf("%d.json", o.Task.WebsiteId))
 
-	// Open crawl results file
-	f, err := os.OpenFile(
-		filePath,
-		os.O_CREATE | os.O_RDWR | os.O_TRUNC,
-		0644,
-	)
+	// Open crawl results file,
+	// keep results up to the checkpoint when resuming
+	flags := os.O_CREATE | os.O_RDWR | os.O_TRUNC
+	if o.resume != nil {
+		flags = os.O_CREATE | os.O_RDWR
+	}
+	f, err := os.OpenFile(filePath, flags, 0644)
 	if err != nil {
 		logrus.WithError(err).
 			Error("Failed saving crawl results")
 		return
 	}
 	defer f.Close()
-	defer os.Remove(filePath)
+
+	if o.resume != nil {
+		err = f.Truncate(o.resume.ResultSize)
+		if err == nil {
+			_, err = f.Seek(0, io.SeekEnd)
+		}
+		if err != nil {
+			logrus.WithError(err).
+				Error("Failed saving crawl results")
+			return
+		}
+	}
 
 	// Listen for exit code of Collect()
 	collectErrC := make(chan error)
 
 	// Block until all results are written
 	// (closes results channel)
-	o.handleCollect(results, f, collectErrC)
+	finished := o.handleCollect(results, f, collectErrC)
 
 	// Exit code of Collect()
 	err = <-collectErrC
 	close(collectErrC)
+	if !finished {
+		// Suspended, the checkpoint needs the results
+		return
+	}
+	defer os.Remove(filePath)
 	if err != nil {
 		logrus.WithError(err).
 			Error("Failed saving crawl results")
 		return
 	}
 
-	// Upload results
-	err = PushResult(&o.Result, f)
+	if o.StopMode() == crawler.StopCancel {
+		// Give the task back to the server
+		if o.Task.WebsiteId != 0 {
+			err = CancelTask(o.Task.WebsiteId)
+			if err != nil {
+				logrus.WithError(err).
+					Error("Failed to cancel task")
+			}
+		}
+		return
+	}
+
+	if o.Task.WebsiteId == 0 {
+		// Not a real result, don't push
+		return
+	}
+
+	// Hand over results to the uploader
+	if err = f.Close(); err == nil {
+		err = SpoolResult(&o.Result, filePath)
+	}
 	if err != nil {
 		logrus.WithError(err).
-			Error("Failed uploading crawl results")
+			Error("Failed saving crawl results")
 		return
 	}
 }
 
-func (o *OD) handleCollect(results chan File, f *os.File, collectErrC chan error) {
+// handleCollect returns false if the task was suspended
+func (o *Remote) handleCollect(results chan crawler.File, f *os.File, collectErrC chan error) bool {
 	// Begin collecting results
-	go o.Task.Collect(results, f, collectErrC)
+	go o.Collect(results, f, collectErrC)
 	defer close(results)
 
 	// Wait for all jobs on remote to finish
+	// or the task to be suspended
 	o.Wait.Wait()
 
-	// Close queue
-	if err := o.WCtx.Queue.Close(); err != nil {
+	// Task can't be suspended anymore
+	finished := o.MarkFinished()
+	o.stopCheckpoints()
+	atomic.AddInt32(&numActiveTasks, -1)
+	if !finished {
+		return false
+	}
+
+	// Close queue, sets status code
+	if err := o.Close(); err != nil {
 		panic(err)
 	}
-	atomic.AddInt32(&numActiveTasks, -1)
+	RemoveCheckpoint(o.Task.WebsiteId)
 
 	// Log finish
 
@@ -173,26 +216,13 @@ func (o *OD) handleCollect(results chan File, f *os.File, collectErrC chan error
 		"id":  o.Task.WebsiteId,
 		"url": o.BaseUri.String(),
 		"duration": time.Since(o.Result.StartTime),
+		"format": o.Result.ListingFormat,
 	}).Info("Crawler finished")
-
-	// Set status code
-	now := time.Now()
-	o.Result.EndTimeUnix = now.Unix()
-	fileCount := atomic.LoadUint64(&o.Result.FileCount)
-	if fileCount == 0 {
-		errorCount := atomic.LoadUint64(&o.Result.ErrorCount)
-		if errorCount == 0 {
-			o.Result.StatusCode = "empty"
-		} else {
-			o.Result.StatusCode = "directory listing failed"
-		}
-	} else {
-		o.Result.StatusCode = "success"
-	}
+	return true
 }
 
-func (t *Task) Collect(results chan File, f *os.File, errC chan<- error) {
-	err := t.collect(results, f)
+func (o *Remote) Collect(results chan crawler.File, f *os.File, errC chan<- error) {
+	err := o.collect(results, f)
 	if err != nil {
 		logrus.WithError(err).
 			Error("Failed saving crawl results")
@@ -200,17 +230,29 @@ func (t *Task) Collect(results chan File, f *os.File, errC chan<- error) {
 	errC <- err
 }
 
-func (t *Task) collect(results chan File, f *os.File) error {
-	for result := range results {
-		result.Path = fasturl.PathUnescape(result.Path)
-		result.Name = fasturl.PathUnescape(result.Name)
-		resJson, err := json.Marshal(result)
-		if err != nil { panic(err) }
-		_, err = f.Write(resJson)
-		if err != nil { return err }
-		_, err = f.Write([]byte{'\n'})
-		if err != nil { return err }
+func (o *Remote) collect(results chan crawler.File, f *os.File) error {
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				return nil
+			}
+			result.Path = fasturl.PathUnescape(result.Path)
+			result.Name = fasturl.PathUnescape(result.Name)
+			resJson, err := json.Marshal(result)
+			if err != nil { panic(err) }
+			_, err = f.Write(resJson)
+			if err != nil { return err }
+			_, err = f.Write([]byte{'\n'})
+			if err != nil { return err }
+
+		case reply := <-o.syncC:
+			// Checkpoint requested the file size
+			size, err := f.Seek(0, io.SeekCurrent)
+			if err != nil {
+				size = -1
+			}
+			reply <- size
+		}
 	}
-
-	return nil
 }
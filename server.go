@@ -1,18 +1,13 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
-	"io"
-	"mime/multipart"
+	"github.com/terorie/od-database-crawler/crawler"
 	"net/http"
 	"net/url"
 	"os"
 	"strconv"
-	"time"
 )
 
 var serverClient = http.Client {
@@ -22,7 +17,7 @@ var serverClient = http.Client {
 
 var serverUserAgent = "od-database-crawler/" + rootCmd.Version
 
-func FetchTask() (t *Task, err error) {
+func FetchTask() (t *crawler.Task, err error) {
 	res, err := serverClient.PostForm(
 		config.ServerUrl + "/task/get",
 		url.Values{ "token": {config.Token} })
@@ -38,7 +33,7 @@ func FetchTask() (t *Task, err error) {
 		return nil, fmt.Errorf("http %s", res.Status)
 	}
 
-	t = new(Task)
+	t = new(crawler.Task)
 	err = json.NewDecoder(res.Body).Decode(t)
 	if _, ok := err.(*json.SyntaxError); ok {
 		return nil, fmt.Errorf("/task/get returned invalid JSON")
@@ -47,103 +42,29 @@ func FetchTask() (t *Task, err error) {
 	return
 }
 
-func PushResult(result *TaskResult, f *os.File) (err error) {
+// PushResult uploads the file list starting at offset
+// and completes the task. progress is called with the
+// offset after each uploaded chunk.
+func PushResult(result *crawler.TaskResult, f *os.File, offset int64, progress func(int64)) (err error) {
 	if result.WebsiteId == 0 {
 		// Not a real result, don't push
 		return nil
 	}
 
-	// Rewind to the beginning of the file
-	_, err = f.Seek(0, 0)
+	err = uploadChunks(result.WebsiteId, f, offset, progress)
 	if err != nil {
-		return
+		return fmt.Errorf("failed to upload file list: %s", err)
 	}
 
-	err = uploadChunks(result.WebsiteId, f)
+	err = uploadResult(result)
 	if err != nil {
-		logrus.Errorf("Failed to upload file list: %s", err)
-		err2 := CancelTask(result.WebsiteId)
-		if err2 != nil {
-			logrus.Error(err2)
-		}
-		return
+		return fmt.Errorf("failed to complete task: %s", err)
 	}
 
-	// Upload result ignoring errors
-	uploadResult(result)
-
 	return
 }
 
-func uploadChunks(websiteId uint64, f *os.File) error {
-	eof := false
-	for iter := 1; !eof; iter++ {
-		// TODO Stream with io.Pipe?
-		var b bytes.Buffer
-
-		multi := multipart.NewWriter(&b)
-
-		// Set upload fields
-		var err error
-		err = multi.WriteField("token", config.Token)
-		if err != nil { return err }
-		err = multi.WriteField("website_id", fmt.Sprintf("%d", websiteId))
-		if err != nil { return err }
-
-		// Copy chunk to file_list
-		formFile, err := multi.CreateFormFile("file_list", "file_list")
-		var n int64
-		n, err = io.CopyN(formFile, f, config.ChunkSize)
-		if err != io.EOF && err != nil {
-			return err
-		}
-		if n == 0 {
-			// Don't upload, no content
-			return nil
-		} else if n < config.ChunkSize {
-			err = nil
-			// Break at end of iteration
-			eof = true
-		}
-
-		multi.Close()
-
-		for retries := 0; retries < viper.GetInt(ConfUploadRetries); retries++ {
-			if retries > 0 {
-				// Error occurred, retry upload
-				time.Sleep(viper.GetDuration(ConfUploadRetryInterval))
-			}
-
-			req, err := http.NewRequest(
-				http.MethodPost,
-				config.ServerUrl + "/task/upload",
-				&b)
-			req.Header.Set("content-type", multi.FormDataContentType())
-			if err != nil { continue }
-
-			res, err := serverClient.Do(req)
-			if err != nil { continue }
-			res.Body.Close()
-
-			if res.StatusCode != http.StatusOK {
-				logrus.WithField("status", res.Status).
-					WithField("part", iter).
-					Errorf("Upload failed")
-				continue
-			}
-
-			// Upload successful
-			break
-		}
-
-		logrus.WithField("id", websiteId).
-			WithField("part", iter).
-			Infof("Uploaded files chunk")
-	}
-	return nil
-}
-
-func uploadResult(result *TaskResult) (err error) {
+func uploadResult(result *crawler.TaskResult) (err error) {
 	resultEnc, err := json.Marshal(result)
 	if err != nil { panic(err) }
 
@@ -158,7 +79,7 @@ func uploadResult(result *TaskResult) (err error) {
 	res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return HttpError{res.StatusCode}
+		return crawler.HttpError{Code: res.StatusCode}
 	}
 
 	return
@@ -186,5 +107,12 @@ type ServerTripper struct{}
 
 func (t *ServerTripper) RoundTrip(req *http.Request) (res *http.Response, err error) {
 	req.Header.Set("User-Agent", serverUserAgent)
-	return http.DefaultTransport.RoundTrip(req)
+	res, err = http.DefaultTransport.RoundTrip(req)
+	if err == nil {
+		// Server announced upload encodings
+		if accept := res.Header.Get("Accept-Encoding"); accept != "" {
+			noteAcceptEncoding(accept)
+		}
+	}
+	return
 }
@@ -6,15 +6,9 @@ import (
 	"github.com/spf13/viper"
 	"math"
 	"runtime"
-	"sync/atomic"
 	"time"
 )
 
-var totalStarted uint64
-var totalDone uint64
-var totalRetries uint64
-var totalAborted uint64
-
 func Stats(c context.Context) {
 	var startedLast uint64 = 0
 	var crawlTicker <-chan time.Time
@@ -33,7 +27,8 @@ func Stats(c context.Context) {
 	for {
 		select {
 		case <-crawlTicker:
-			startedNow := atomic.LoadUint64(&totalStarted)
+			stats := engine.Stats()
+			startedNow := stats.Started
 
 			perSecond := float64(startedNow - startedLast) /
 				crawlInterval.Seconds()
@@ -49,9 +44,14 @@ func Stats(c context.Context) {
 
 			logrus.WithFields(logrus.Fields{
 				"per_second": perSecond,
-				"done":    atomic.LoadUint64(&totalDone),
-				"retries": atomic.LoadUint64(&totalRetries),
-				"aborted": atomic.LoadUint64(&totalAborted),
+				"done":    stats.Done,
+				"retries": stats.Retries,
+				"aborted": stats.Aborted,
+				"redirect_loops": stats.RedirectLoops,
+				"rate_limits": stats.RateLimits,
+				"traps": stats.Traps,
+				"backoff": maxBackoff(),
+				"workers": totalWorkers(),
 			}).Info("Crawl Stats")
 
 			startedLast = startedNow
@@ -61,7 +61,7 @@ func Stats(c context.Context) {
 			runtime.ReadMemStats(&mem)
 
 			logrus.WithFields(logrus.Fields{
-				"queue_count": atomic.LoadInt64(&totalQueued),
+				"queue_count": engine.Stats().Queued,
 				"heap": FormatByteCount(mem.Alloc),
 				"objects": mem.HeapObjects,
 				"num_gc": mem.NumGC,
@@ -72,3 +72,29 @@ func Stats(c context.Context) {
 		}
 	}
 }
+
+// maxBackoff returns the highest rate limit
+// delay of all active tasks.
+func maxBackoff() (max time.Duration) {
+	activeTasksLock.Lock()
+	defer activeTasksLock.Unlock()
+
+	for _, od := range activeTasks {
+		if d := od.WCtx.Limiter.MaxDelay(); d > max {
+			max = d
+		}
+	}
+	return
+}
+
+// totalWorkers returns the connections
+// currently used by all active tasks.
+func totalWorkers() (n int) {
+	activeTasksLock.Lock()
+	defer activeTasksLock.Unlock()
+
+	for _, od := range activeTasks {
+		n += od.Workers()
+	}
+	return
+}
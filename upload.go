@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/terorie/od-database-crawler/crawler"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request body encodings accepted by the server.
+// Learned from Accept-Encoding response headers (RFC 7694).
+var uploadEncodings struct {
+	sync.Mutex
+	known    bool
+	accepted map[string]bool
+}
+
+func noteAcceptEncoding(header string) {
+	uploadEncodings.Lock()
+	defer uploadEncodings.Unlock()
+
+	uploadEncodings.known = true
+	uploadEncodings.accepted = make(map[string]bool)
+	for _, token := range strings.Split(header, ",") {
+		// Ignore weights
+		if i := strings.IndexByte(token, ';'); i >= 0 {
+			token = token[:i]
+		}
+		token = strings.ToLower(strings.TrimSpace(token))
+		if token != "" {
+			uploadEncodings.accepted[token] = true
+		}
+	}
+}
+
+// uploadEncoding returns the Content-Encoding
+// for result uploads or "" for no compression.
+func uploadEncoding() string {
+	uploadEncodings.Lock()
+	defer uploadEncodings.Unlock()
+
+	var prefs []string
+	switch config.UploadCompression {
+	case "gzip":
+		prefs = []string{"gzip"}
+	case "zstd":
+		prefs = []string{"zstd"}
+	case "auto":
+		prefs = []string{"zstd", "gzip"}
+	default:
+		return ""
+	}
+
+	for _, enc := range prefs {
+		if !uploadEncodings.known {
+			// Only compress unasked if configured
+			if config.UploadCompression != "auto" {
+				return enc
+			}
+		} else if uploadEncodings.accepted[enc] {
+			return enc
+		}
+	}
+	return ""
+}
+
+func newEncoder(w io.Writer, encoding string) (io.WriteCloser, error) {
+	switch encoding {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	case "":
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %s", encoding)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func uploadChunks(websiteId uint64, f *os.File, offset int64, progress func(int64)) error {
+	info, err := f.Stat()
+	if err != nil { return err }
+	size := info.Size()
+
+	iter := 1
+	for start := offset; start < size; iter++ {
+		end, err := chunkEnd(f, start, size, config.ChunkSize)
+		if err != nil { return err }
+
+		chunk := io.NewSectionReader(f, start, end - start)
+		err = uploadChunk(websiteId, chunk, iter)
+		if err != nil { return err }
+
+		logrus.WithField("id", websiteId).
+			WithField("part", iter).
+			Infof("Uploaded files chunk")
+
+		if progress != nil {
+			progress(end)
+		}
+		start = end
+	}
+	return nil
+}
+
+func uploadChunk(websiteId uint64, chunk *io.SectionReader, iter int) (err error) {
+	maxRetries := viper.GetInt(ConfUploadRetries)
+	for retries := 0; retries < maxRetries; retries++ {
+		if retries > 0 {
+			// Error occurred, retry upload
+			time.Sleep(viper.GetDuration(ConfUploadRetryInterval))
+		}
+
+		encoding := uploadEncoding()
+		var res *http.Response
+		res, err = postChunk(websiteId, chunk, encoding)
+		if err != nil {
+			metricUploads.Add(1, "failure")
+			logrus.WithError(err).
+				WithField("part", iter).
+				Errorf("Upload failed")
+			continue
+		}
+		res.Body.Close()
+
+		if res.StatusCode == http.StatusUnsupportedMediaType && encoding != "" {
+			// Server doesn't take this encoding,
+			// try again with the ones it lists.
+			noteAcceptEncoding(res.Header.Get("Accept-Encoding"))
+			if uploadEncoding() != encoding {
+				retries--
+				continue
+			}
+		}
+
+		if res.StatusCode != http.StatusOK {
+			metricUploads.Add(1, "failure")
+			err = crawler.HttpError{Code: res.StatusCode}
+			logrus.WithField("status", res.Status).
+				WithField("part", iter).
+				Errorf("Upload failed")
+			continue
+		}
+
+		// Upload successful
+		metricUploads.Add(1, "success")
+		return nil
+	}
+	return fmt.Errorf("giving up on part %d: %s", iter, err)
+}
+
+// postChunk streams a chunk from disk to the server
+func postChunk(websiteId uint64, chunk *io.SectionReader, encoding string) (*http.Response, error) {
+	if _, err := chunk.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+
+	enc, err := newEncoder(pw, encoding)
+	if err != nil { return nil, err }
+	multi := multipart.NewWriter(enc)
+
+	go func() {
+		err := writeChunkForm(multi, websiteId, chunk)
+		if err == nil {
+			err = enc.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequest(
+		http.MethodPost,
+		config.ServerUrl + "/task/upload",
+		pr)
+	if err != nil { return nil, err }
+	req.Header.Set("content-type", multi.FormDataContentType())
+	if encoding != "" {
+		req.Header.Set("content-encoding", encoding)
+	}
+
+	return serverClient.Do(req)
+}
+
+func writeChunkForm(multi *multipart.Writer, websiteId uint64, chunk io.Reader) error {
+	// Set upload fields
+	err := multi.WriteField("token", config.Token)
+	if err != nil { return err }
+	err = multi.WriteField("website_id", fmt.Sprintf("%d", websiteId))
+	if err != nil { return err }
+
+	// Copy chunk to file_list
+	formFile, err := multi.CreateFormFile("file_list", "file_list")
+	if err != nil { return err }
+	_, err = io.Copy(formFile, chunk)
+	if err != nil { return err }
+
+	return multi.Close()
+}
+
+// chunkEnd returns the end of the chunk beginning at start.
+// Chunks end after the last newline within maxSize bytes,
+// so no file record is split. A record longer than
+// maxSize becomes a chunk of its own.
+func chunkEnd(f io.ReaderAt, start, size, maxSize int64) (int64, error) {
+	if size - start <= maxSize {
+		return size, nil
+	}
+
+	buf := make([]byte, 32 * 1024)
+
+	// Search backwards for the last newline
+	for pos := start + maxSize; pos > start; {
+		n := int64(len(buf))
+		if pos - start < n {
+			n = pos - start
+		}
+		if _, err := f.ReadAt(buf[:n], pos - n); err != nil {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return pos - n + int64(i) + 1, nil
+		}
+		pos -= n
+	}
+
+	// Search forward for the end of the record
+	for pos := start + maxSize; pos < size; {
+		n := int64(len(buf))
+		if size - pos < n {
+			n = size - pos
+		}
+		if _, err := f.ReadAt(buf[:n], pos); err != nil {
+			return 0, err
+		}
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return pos + int64(i) + 1, nil
+		}
+		pos += n
+	}
+	return size, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/viper"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestChunkEnd(t *testing.T) {
+	data := "aaaa\nbbbbbbbbbbbb\ncc\n"
+	r := strings.NewReader(data)
+	size := int64(len(data))
+	tests := []struct {
+		start, max, end int64
+	}{
+		{0, 100, size},
+		{0, 5, 5},
+		{0, 8, 5},
+		// Record longer than chunk
+		{5, 4, 18},
+		{5, 13, 18},
+		{18, 4, size},
+	}
+	for _, test := range tests {
+		end, err := chunkEnd(r, test.start, size, test.max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if end != test.end {
+			t.Errorf("chunkEnd(%d, %d) = %d, want %d",
+				test.start, test.max, end, test.end)
+		}
+	}
+}
+
+// uploadTestServer collects uploaded chunks
+type uploadTestServer struct {
+	*httptest.Server
+	m         sync.Mutex
+	chunks    []string
+	encodings []string
+	// Supported Content-Encodings
+	accept    map[string]bool
+}
+
+func newUploadTestServer(accept ...string) *uploadTestServer {
+	s := &uploadTestServer{accept: make(map[string]bool)}
+	for _, enc := range accept {
+		s.accept[enc] = true
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *uploadTestServer) handle(w http.ResponseWriter, r *http.Request) {
+	var accept []string
+	for enc := range s.accept {
+		accept = append(accept, enc)
+	}
+	w.Header().Set("Accept-Encoding", strings.Join(accept, ", "))
+
+	enc := r.Header.Get("Content-Encoding")
+	var body io.Reader = r.Body
+	switch {
+	case enc == "":
+	case !s.accept[enc]:
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	case enc == "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = gz
+	case enc == "zstd":
+		zr, err := zstd.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer zr.Close()
+		body = zr
+	}
+
+	r.Body = ioutil.NopCloser(body)
+	r.Header.Del("Content-Encoding")
+	file, _, err := r.FormFile("file_list")
+	if err != nil || r.FormValue("website_id") != "42" {
+		http.Error(w, fmt.Sprint("bad form: ", err), http.StatusBadRequest)
+		return
+	}
+	chunk, _ := ioutil.ReadAll(file)
+
+	s.m.Lock()
+	s.chunks = append(s.chunks, string(chunk))
+	s.encodings = append(s.encodings, enc)
+	s.m.Unlock()
+}
+
+func uploadTest(t *testing.T, s *uploadTestServer, compression string) {
+	t.Helper()
+	defer func(url, comp string, chunkSize int64) {
+		config.ServerUrl = url
+		config.UploadCompression = comp
+		config.ChunkSize = chunkSize
+	}(config.ServerUrl, config.UploadCompression, config.ChunkSize)
+	config.ServerUrl = s.URL
+	config.UploadCompression = compression
+	config.ChunkSize = 100
+	viper.Set(ConfUploadRetries, 2)
+	viper.Set(ConfUploadRetryInterval, 0)
+
+	f, err := ioutil.TempFile("", "od-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	var expected bytes.Buffer
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&expected, `{"name":"file%d.bin","size":%d,"mtime":0,"path":"pub"}` + "\n", i, i * 100)
+	}
+	f.Write(expected.Bytes())
+
+	if err := uploadChunks(42, f, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.chunks) < 2 {
+		t.Fatalf("Expected multiple chunks, got %d", len(s.chunks))
+	}
+	var got strings.Builder
+	for _, chunk := range s.chunks {
+		if len(chunk) > 100 || !strings.HasSuffix(chunk, "\n") {
+			t.Errorf("Chunk not aligned to records: %q", chunk)
+		}
+		got.WriteString(chunk)
+	}
+	if got.String() != expected.String() {
+		t.Error("Uploaded data doesn't match file")
+	}
+}
+
+func resetUploadEncodings() {
+	uploadEncodings.Lock()
+	uploadEncodings.known = false
+	uploadEncodings.accepted = nil
+	uploadEncodings.Unlock()
+}
+
+func TestUploadChunksPlain(t *testing.T) {
+	resetUploadEncodings()
+	defer resetUploadEncodings()
+	s := newUploadTestServer()
+	defer s.Close()
+
+	uploadTest(t, s, "auto")
+	for _, enc := range s.encodings {
+		if enc != "" {
+			t.Errorf("Unexpected encoding %s", enc)
+		}
+	}
+}
+
+func TestUploadChunksZstd(t *testing.T) {
+	resetUploadEncodings()
+	defer resetUploadEncodings()
+	s := newUploadTestServer("zstd", "gzip")
+	defer s.Close()
+
+	uploadTest(t, s, "zstd")
+	for _, enc := range s.encodings {
+		if enc != "zstd" {
+			t.Errorf("Expected zstd, got %q", enc)
+		}
+	}
+}
+
+func TestUploadChunksNegotiate(t *testing.T) {
+	resetUploadEncodings()
+	defer resetUploadEncodings()
+	// Server rejects zstd and lists gzip
+	s := newUploadTestServer("gzip")
+	defer s.Close()
+
+	uploadTest(t, s, "zstd")
+	for _, enc := range s.encodings {
+		if enc != "" {
+			t.Errorf("Expected fallback to no compression, got %q", enc)
+		}
+	}
+
+	resetUploadEncodings()
+	s.chunks, s.encodings = nil, nil
+	uploadTest(t, s, "auto")
+	for i, enc := range s.encodings {
+		// Learned from the first response
+		if i > 0 && enc != "gzip" {
+			t.Errorf("Expected gzip, got %q", enc)
+		}
+	}
+}